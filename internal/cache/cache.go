@@ -0,0 +1,294 @@
+// Package cache stores command output on disk so identical requests can be served without running the command again.
+package cache
+
+import (
+	"container/list"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaSuffix is appended to an entry's file name to store the source URI it was derived from,
+// after its namespace and a newline if it has one.
+const metaSuffix = ".source"
+
+// Cache is a size bounded, least recently used on-disk cache.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type entry struct {
+	key       string
+	namespace string
+	source    string
+	size      int64
+}
+
+// New opens the cache in dir, creating it if needed.
+// Entries already in dir are kept, ordered by when they were last used.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		entry
+		modTime int64
+	}
+	var entries []found
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, metaSuffix) {
+			// clean up anything left behind by an interrupted write
+			if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp") {
+				_ = os.Remove(filepath.Join(c.dir, name))
+			}
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		meta, _ := os.ReadFile(c.path(name) + metaSuffix)
+		namespace, source, ok := strings.Cut(string(meta), "\n")
+		if !ok {
+			namespace, source = "", namespace
+		}
+		entries = append(entries, found{
+			entry:   entry{key: name, namespace: namespace, source: source, size: info.Size()},
+			modTime: info.ModTime().UnixNano(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime > entries[j].modTime })
+	for _, e := range entries {
+		c.entries[e.key] = c.lru.PushBack(&e.entry)
+		c.size += e.size
+	}
+	c.evict()
+
+	return nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Open returns the cached output for key, marking it as recently used.
+// The caller must close the returned file.
+func (c *Cache) Open(key string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Unable to open cache entry", "key", key, "err", err)
+		}
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	// keep the order when the cache is reloaded
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+
+	return f, true
+}
+
+// Create starts writing the output for key, derived from the source URI.
+// The entry belongs to namespace, so it's only purged with the namespace's entries.
+// The output is only added to the cache once the writer is committed.
+func (c *Cache) Create(key, namespace, source string) (*Writer, error) {
+	tmp := filepath.Join(c.dir, fmt.Sprintf(".%s.%s.tmp", key, rand.Text()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		file:      f,
+		cache:     c,
+		key:       key,
+		namespace: namespace,
+		source:    source,
+	}, nil
+}
+
+// Purge removes the namespace's entries derived from the source URI, or all of them if source is empty.
+// It returns the number of entries removed.
+func (c *Cache) Purge(namespace, source string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry)
+		if e.namespace == namespace && (source == "" || e.source == source) {
+			c.remove(el)
+			purged++
+		}
+		el = next
+	}
+
+	return purged
+}
+
+// Size returns the total size of the cached entries in bytes.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// Len returns the number of cached entries.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache) add(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		c.size -= el.Value.(*entry).size
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[e.key] = c.lru.PushFront(e)
+	}
+	c.size += e.size
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits in maxBytes.
+func (c *Cache) evict() {
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		slog.Debug("Evicting cache entry", "key", el.Value.(*entry).key, "size", el.Value.(*entry).size)
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	c.size -= e.size
+
+	// open files are unaffected, so entries being served are still sent in full
+	for _, p := range []string{c.path(e.key), c.path(e.key) + metaSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Unable to remove cache entry", "path", p, "err", err)
+		}
+	}
+}
+
+// Writer writes command output to the cache.
+// Write errors don't fail the write, since the output is still sent to the client,
+// but the output is then discarded instead of committed.
+type Writer struct {
+	file      *os.File
+	cache     *Cache
+	key       string
+	namespace string
+	source    string
+	size      int64
+	err       error
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		slog.Error("Unable to write cache entry", "key", w.key, "err", err)
+		w.err = err
+	}
+
+	return len(p), nil
+}
+
+// Commit adds the written output to the cache.
+func (w *Writer) Commit() error {
+	tmp := w.file.Name()
+	if w.err == nil {
+		w.err = w.file.Close()
+	} else {
+		w.file.Close()
+	}
+	if w.err != nil {
+		_ = os.Remove(tmp)
+		return w.err
+	}
+	if w.cache.maxBytes > 0 && w.size > w.cache.maxBytes {
+		_ = os.Remove(tmp)
+		return nil
+	}
+
+	meta := w.source
+	if w.namespace != "" {
+		meta = w.namespace + "\n" + meta
+	}
+	if err := os.WriteFile(w.cache.path(w.key)+metaSuffix, []byte(meta), 0640); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, w.cache.path(w.key)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	w.cache.add(&entry{key: w.key, namespace: w.namespace, source: w.source, size: w.size})
+
+	return nil
+}
+
+// Abort discards the written output.
+func (w *Writer) Abort() {
+	w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, c *Cache, key, source, data string) {
+	t.Helper()
+	w, err := c.Create(key, "", source)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+}
+
+func get(t *testing.T, c *Cache, key string) (string, bool) {
+	t.Helper()
+	f, ok := c.Open(key)
+	if !ok {
+		return "", false
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b), true
+}
+
+func TestCache(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	put(t, c, "a", "https://islandora.dev/a.tiff", "aaaa")
+	put(t, c, "b", "https://islandora.dev/b.tiff", "bbbb")
+
+	data, ok := get(t, c, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", data)
+
+	// b is the least recently used, so it's evicted to make room
+	put(t, c, "c", "https://islandora.dev/a.tiff", "cccc")
+	_, ok = get(t, c, "b")
+	assert.False(t, ok)
+	_, ok = get(t, c, "a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), c.Size())
+
+	// output bigger than the whole cache isn't kept
+	put(t, c, "d", "https://islandora.dev/d.tiff", "ddddddddddd")
+	_, ok = get(t, c, "d")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestCache_Abort(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0)
+	require.NoError(t, err)
+
+	w, err := c.Create("a", "", "")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	w.Abort()
+
+	_, ok := get(t, c, "a")
+	assert.False(t, ok)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCache_Purge(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	put(t, c, "a", "https://islandora.dev/a.tiff", "a")
+	put(t, c, "a-thumb", "https://islandora.dev/a.tiff", "a")
+	put(t, c, "b", "https://islandora.dev/b.tiff", "b")
+
+	assert.Equal(t, 2, c.Purge("", "https://islandora.dev/a.tiff"))
+	_, ok := get(t, c, "a")
+	assert.False(t, ok)
+	_, ok = get(t, c, "b")
+	assert.True(t, ok)
+
+	assert.Equal(t, 1, c.Purge("", ""))
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, int64(0), c.Size())
+}
+
+func TestCache_Reload(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0)
+	require.NoError(t, err)
+	put(t, c, "a", "https://islandora.dev/a.tiff", "aaaa")
+	// an interrupted write is cleaned up
+	_, err = c.Create("b", "", "https://islandora.dev/b.tiff")
+	require.NoError(t, err)
+
+	c, err = New(dir, 0)
+	require.NoError(t, err)
+	data, ok := get(t, c, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", data)
+	assert.Equal(t, 1, c.Len())
+
+	// the source URI is kept so entries can still be purged by source
+	assert.Equal(t, 1, c.Purge("", "https://islandora.dev/a.tiff"))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCache_PurgeNamespace(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 0)
+	require.NoError(t, err)
+
+	put(t, c, "a", "https://islandora.dev/a.tiff", "a")
+	for key, source := range map[string]string{"b": "https://islandora.dev/a.tiff", "c": "https://islandora.dev/c.tiff"} {
+		w, err := c.Create(key, "/b", source)
+		require.NoError(t, err)
+		require.NoError(t, w.Commit())
+	}
+
+	// namespaces are kept when the cache is reloaded
+	c, err = New(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Purge("/b", "https://islandora.dev/a.tiff"))
+	assert.Equal(t, 1, c.Purge("/b", ""))
+	_, ok := get(t, c, "a")
+	assert.True(t, ok)
+	assert.Equal(t, 0, c.Purge("/b", ""))
+	assert.Equal(t, 1, c.Purge("", ""))
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// defaultArgPattern is the regex X-Islandora-Args arguments must match when the command doesn't set one.
+const defaultArgPattern = `^[a-zA-Z0-9._\-:\/@ =]+$`
+
+// ArgPolicy restricts the arguments the X-Islandora-Args header can pass to a command with %args.
+//
+// swagger:model ArgPolicy
+type ArgPolicy struct {
+	// Regex every argument must match.
+	// It isn't checked when allowInsecureArgs is enabled.
+	//
+	// required: false
+	// default: ^[a-zA-Z0-9._\-:\/@ =]+$
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Maximum number of arguments.
+	//
+	// required: false
+	// default: 0 (unlimited)
+	MaxArgs int `yaml:"maxArgs,omitempty"`
+
+	// Flags the arguments can have, mapped to a regex their value must match.
+	// A flag mapped to an empty string doesn't take a value.
+	// Values are either the next argument or follow an = in the same argument.
+	// When set, any other flag or argument is rejected.
+	//
+	// required: false
+	AllowedFlags map[string]string `yaml:"allowedFlags,omitempty"`
+
+	// Arguments starting with any of these are rejected, e.g. -write or @ for ImageMagick.
+	//
+	// required: false
+	ForbiddenFlags []string `yaml:"forbiddenFlags,omitempty"`
+}
+
+// ArgError describes an X-Islandora-Args argument rejected by the command's argument policy.
+// Its message is safe to send back to the client.
+type ArgError struct {
+	// Arg is the rejected argument, empty when the arguments are rejected as a whole
+	Arg    string
+	Reason string
+}
+
+func (e *ArgError) Error() string {
+	if e.Arg == "" {
+		return e.Reason
+	}
+
+	return fmt.Sprintf("argument %q %s", e.Arg, e.Reason)
+}
+
+// Validate checks the argument policy for configuration errors.
+func (p *ArgPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxArgs < 0 {
+		return errors.New("argPolicy maxArgs can't be negative")
+	}
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("invalid argPolicy pattern: %w", err)
+	}
+	for flag, pattern := range p.AllowedFlags {
+		if !isFlag(flag) {
+			return fmt.Errorf("argPolicy allowedFlags %q must start with - or +", flag)
+		}
+		if _, err := regexp.Compile(anchor(pattern)); err != nil {
+			return fmt.Errorf("invalid argPolicy pattern for %s: %w", flag, err)
+		}
+	}
+	for _, flag := range p.ForbiddenFlags {
+		if flag == "" {
+			return errors.New("argPolicy forbiddenFlags can't be empty")
+		}
+	}
+
+	return nil
+}
+
+// PassedArgs splits the X-Islandora-Args header value args into arguments for the command,
+// rejecting any its argument policy doesn't allow with an *ArgError.
+// The preset named by preset, and any preset:name arguments, are replaced with the preset's arguments.
+func (c Command) PassedArgs(args, preset string) ([]string, error) {
+	tokens, err := shlex.Split(args)
+	if err != nil {
+		return nil, fmt.Errorf("error splitting args %s: %v", args, err)
+	}
+
+	passedArgs := []string{}
+	if preset != "" {
+		presetArgs, err := c.presetArgs(preset)
+		if err != nil {
+			return nil, err
+		}
+		passedArgs = append(passedArgs, presetArgs...)
+	}
+
+	freeform := []string{}
+	for _, a := range tokens {
+		name, ok := strings.CutPrefix(a, presetPrefix)
+		if !ok {
+			freeform = append(freeform, a)
+			passedArgs = append(passedArgs, a)
+			continue
+		}
+		presetArgs, err := c.presetArgs(name)
+		if err != nil {
+			return nil, err
+		}
+		passedArgs = append(passedArgs, presetArgs...)
+	}
+	if len(freeform) > 0 && !c.FreeformArgs() {
+		return nil, &ArgError{Arg: freeform[0], Reason: "is not allowed, only presets can be used"}
+	}
+	// presets are trusted, so they're checked where they are but their arguments aren't
+	if err := c.ArgPolicy.check(tokens, c.AllowInsecureArgs); err != nil {
+		return nil, err
+	}
+
+	return passedArgs, nil
+}
+
+// check returns an *ArgError for the first argument the policy rejects.
+// A nil policy only checks the arguments against the default pattern.
+// Presets in args aren't checked themselves, but can't be used as a flag's value.
+func (p *ArgPolicy) check(args []string, allowInsecure bool) error {
+	if p == nil {
+		p = &ArgPolicy{}
+	}
+	freeform := slices.DeleteFunc(slices.Clone(args), isPreset)
+	if p.MaxArgs > 0 && len(freeform) > p.MaxArgs {
+		return &ArgError{Reason: fmt.Sprintf("too many arguments: %d, at most %d are allowed", len(freeform), p.MaxArgs)}
+	}
+
+	pattern := p.Pattern
+	if pattern == "" {
+		pattern = defaultArgPattern
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile regex: %v", err)
+	}
+	for _, a := range freeform {
+		for _, flag := range p.ForbiddenFlags {
+			if strings.HasPrefix(a, flag) {
+				return &ArgError{Arg: a, Reason: "is forbidden"}
+			}
+		}
+		if !allowInsecure && !regex.MatchString(a) {
+			return &ArgError{Arg: a, Reason: "has characters that aren't allowed"}
+		}
+	}
+
+	if len(p.AllowedFlags) == 0 {
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if isPreset(a) {
+			continue
+		}
+		if !isFlag(a) {
+			return &ArgError{Arg: a, Reason: "is not an allowed flag"}
+		}
+
+		flag, value, inline := a, "", false
+		pattern, ok := p.AllowedFlags[a]
+		if !ok {
+			flag, value, inline = strings.Cut(a, "=")
+			pattern, ok = p.AllowedFlags[flag]
+		}
+		if !ok {
+			return &ArgError{Arg: a, Reason: "is not an allowed flag"}
+		}
+
+		if pattern == "" {
+			if inline {
+				return &ArgError{Arg: a, Reason: "doesn't take a value"}
+			}
+			continue
+		}
+		if !inline {
+			if i+1 == len(args) {
+				return &ArgError{Arg: a, Reason: "needs a value"}
+			}
+			i++
+			value = args[i]
+			// the value is checked, not the preset's arguments that replace it
+			if isPreset(value) {
+				return &ArgError{Arg: value, Reason: "can't be the value of " + flag}
+			}
+		}
+		regex, err := regexp.Compile(anchor(pattern))
+		if err != nil {
+			return fmt.Errorf("failed to compile regex: %v", err)
+		}
+		if !regex.MatchString(value) {
+			return &ArgError{Arg: value, Reason: "is not an allowed value for " + flag}
+		}
+	}
+
+	return nil
+}
+
+// isPreset reports whether the argument a selects a preset, like preset:thumbnail.
+func isPreset(a string) bool {
+	return strings.HasPrefix(a, presetPrefix)
+}
+
+// isFlag reports whether the argument a is a flag, like -resize or ImageMagick's +repage.
+func isFlag(a string) bool {
+	return len(a) > 1 && (a[0] == '-' || a[0] == '+')
+}
+
+// anchor makes pattern match a whole value.
+func anchor(pattern string) string {
+	return `^(?:` + pattern + `)$`
+}
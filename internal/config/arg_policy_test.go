@@ -0,0 +1,169 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand_PassedArgs(t *testing.T) {
+	imagemagick := &ArgPolicy{
+		Pattern: `^[a-zA-Z0-9.+\-=<>^]+$`,
+		MaxArgs: 8,
+		AllowedFlags: map[string]string{
+			"-quality":  `[0-9]{1,3}`,
+			"-resize":   `[0-9]+x[0-9]+[!<>^]?`,
+			"-strip":    "",
+			"+repage":   "",
+			"--density": `[0-9]+`,
+		},
+		ForbiddenFlags: []string{"-write", "@"},
+	}
+	ffmpeg := &ArgPolicy{
+		Pattern:        `^[a-zA-Z0-9._\-:=,\[\]]+$`,
+		ForbiddenFlags: []string{"-f"},
+	}
+
+	tests := []struct {
+		name          string
+		policy        *ArgPolicy
+		allowInsecure bool
+		args          string
+		want          []string
+		wantError     string
+	}{
+		{
+			name: "default pattern",
+			args: "-quality 80 -resize 100x100",
+			want: []string{"-quality", "80", "-resize", "100x100"},
+		},
+		{
+			name:      "default pattern rejects",
+			args:      "-vf scale=320:-1,crop=100:100",
+			wantError: `argument "scale=320:-1,crop=100:100" has characters that aren't allowed`,
+		},
+		{
+			name:   "custom pattern",
+			policy: ffmpeg,
+			args:   "-vf scale=320:-1,crop=100:100 -map [v]",
+			want:   []string{"-vf", "scale=320:-1,crop=100:100", "-map", "[v]"},
+		},
+		{
+			name:      "forbidden flag",
+			policy:    ffmpeg,
+			args:      "-f image2",
+			wantError: `argument "-f" is forbidden`,
+		},
+		{
+			name:   "allowed flags",
+			policy: imagemagick,
+			args:   "-quality 80 -resize 300x300> -strip +repage --density=300",
+			want:   []string{"-quality", "80", "-resize", "300x300>", "-strip", "+repage", "--density=300"},
+		},
+		{
+			name:          "allowed flags with allowInsecureArgs",
+			policy:        imagemagick,
+			allowInsecure: true,
+			args:          "-resize 300x300!",
+			want:          []string{"-resize", "300x300!"},
+		},
+		{
+			name:      "flag that isn't allowed",
+			policy:    imagemagick,
+			args:      "-quality 80 -monitor",
+			wantError: `argument "-monitor" is not an allowed flag`,
+		},
+		{
+			name:      "positional argument",
+			policy:    imagemagick,
+			args:      "-strip output.png",
+			wantError: `argument "output.png" is not an allowed flag`,
+		},
+		{
+			name:      "value that isn't allowed",
+			policy:    imagemagick,
+			args:      "-quality 8000",
+			wantError: `argument "8000" is not an allowed value for -quality`,
+		},
+		{
+			name:      "inline value that isn't allowed",
+			policy:    imagemagick,
+			args:      "--density=high",
+			wantError: `argument "high" is not an allowed value for --density`,
+		},
+		{
+			name:      "missing value",
+			policy:    imagemagick,
+			args:      "-strip -quality",
+			wantError: `argument "-quality" needs a value`,
+		},
+		{
+			name:      "value for a flag without one",
+			policy:    imagemagick,
+			args:      "-strip=yes",
+			wantError: `argument "-strip=yes" doesn't take a value`,
+		},
+		{
+			name:          "forbidden even with allowInsecureArgs",
+			policy:        imagemagick,
+			allowInsecure: true,
+			args:          "-write out.png",
+			wantError:     `argument "-write" is forbidden`,
+		},
+		{
+			name:      "file include",
+			policy:    imagemagick,
+			args:      "-quality @/etc/passwd",
+			wantError: `argument "@/etc/passwd" is forbidden`,
+		},
+		{
+			name:      "too many arguments",
+			policy:    imagemagick,
+			args:      "-strip -strip -strip -strip -strip -strip -strip -strip -strip",
+			wantError: "too many arguments: 9, at most 8 are allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Command{ArgPolicy: tt.policy, AllowInsecureArgs: tt.allowInsecure}.PassedArgs(tt.args, "")
+			if tt.wantError != "" {
+				var argErr *ArgError
+				require.True(t, errors.As(err, &argErr), "got %v", err)
+				assert.Equal(t, tt.wantError, argErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestArgPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *ArgPolicy
+		wantError bool
+	}{
+		{name: "nil", policy: nil},
+		{name: "valid", policy: &ArgPolicy{Pattern: `^[a-z]+$`, MaxArgs: 2, AllowedFlags: map[string]string{"-x": `[0-9]+`, "+y": ""}, ForbiddenFlags: []string{"@"}}},
+		{name: "negative maxArgs", policy: &ArgPolicy{MaxArgs: -1}, wantError: true},
+		{name: "invalid pattern", policy: &ArgPolicy{Pattern: `[`}, wantError: true},
+		{name: "invalid flag pattern", policy: &ArgPolicy{AllowedFlags: map[string]string{"-x": `(`}}, wantError: true},
+		{name: "flag without a dash", policy: &ArgPolicy{AllowedFlags: map[string]string{"x": ""}}, wantError: true},
+		{name: "empty forbidden flag", policy: &ArgPolicy{ForbiddenFlags: []string{""}}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
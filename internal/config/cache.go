@@ -0,0 +1,35 @@
+package config
+
+import "errors"
+
+// CacheConfig configures the on-disk cache of command output.
+//
+// swagger:model CacheConfig
+type CacheConfig struct {
+	// Directory to store cached output in.
+	//
+	// required: true
+	Dir string `yaml:"dir"`
+
+	// Maximum total size of the cached output in bytes.
+	// The least recently used output is evicted to stay under this size. If zero, the cache is unbounded.
+	//
+	// required: false
+	// default: 0
+	MaxBytes int64 `yaml:"maxBytes,omitempty"`
+}
+
+// Validate checks the cache config for configuration errors.
+func (c *CacheConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Dir == "" {
+		return errors.New("cache dir is required")
+	}
+	if c.MaxBytes < 0 {
+		return errors.New("cache maxBytes can't be negative")
+	}
+
+	return nil
+}
@@ -1,18 +1,19 @@
 package config
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"sync"
+	"text/template"
 
-	"github.com/google/shlex"
 	"github.com/islandora/scyllaridae/pkg/api"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -43,10 +44,133 @@ type ServerConfig struct {
 	// required: false
 	CmdByMimeType map[string]Command `yaml:"cmdByMimeType"`
 
+	// Rules selecting the command to run from commands, checked in order before cmdByMimeType.
+	// The first route matching the request is used.
+	//
+	// required: false
+	Routes []Route `yaml:"routes,omitempty"`
+
+	// Commands routes can select, by name.
+	//
+	// required: false
+	Commands map[string]Command `yaml:"commands,omitempty"`
+
 	// Commands and arguments ran by MIME type based on the destination file format
 	//
 	// required: false
 	MimeTypeFromDestination bool `yaml:"mimeTypeFromDestination,omitempty"`
+
+	// Detect the source MIME type from the first bytes of the source
+	// instead of trusting the Content-Type it was sent with.
+	//
+	// required: false
+	// default: false
+	SniffMimeType bool `yaml:"sniffMimeType,omitempty"`
+
+	// Serve HTTPS directly instead of plaintext HTTP.
+	// If not set, the server listens for plaintext HTTP.
+	//
+	// required: false
+	TLS *TLSConfig `yaml:"tls,omitempty"`
+
+	// Restricts which source URIs are fetched.
+	// If not set, any http(s) host is allowed except loopback and link-local addresses.
+	//
+	// required: false
+	SourcePolicy *SourcePolicy `yaml:"sourcePolicy,omitempty"`
+
+	// Timeouts, proxy, CA bundle and retries for the HTTP client fetching source URIs.
+	//
+	// required: false
+	Fetch *FetchConfig `yaml:"fetch,omitempty"`
+
+	// Local directories and S3 storage to open file:// and s3:// source URIs from.
+	//
+	// required: false
+	Sources *SourcesConfig `yaml:"sources,omitempty"`
+
+	// Drupal stream wrapper schemes, e.g. private, mapped to the directories their files are stored in.
+	// Sources and derivatives using these schemes are read and written directly on disk.
+	//
+	// required: false
+	StreamWrappers map[string]StreamWrapper `yaml:"streamWrappers,omitempty"`
+
+	// Cache command output on disk, keyed by the source's version, the command and the destination MIME type.
+	// If not set, output isn't cached.
+	//
+	// required: false
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+
+	// Share the output of a running command with identical requests,
+	// i.e. requests for the same source URI, command arguments and destination MIME type,
+	// instead of running the command again for each of them.
+	//
+	// required: false
+	// default: true
+	Coalesce *bool `yaml:"coalesceRequests,omitempty"`
+
+	// Rate limits for each client IP, JWT subject and event actor.
+	// Requests over a limit are rejected with 429 Too Many Requests.
+	//
+	// required: false
+	RateLimit *RateLimitConfig `yaml:"rateLimit,omitempty"`
+
+	// Secrets to remove from logs.
+	//
+	// required: false
+	Redact *RedactConfig `yaml:"redact,omitempty"`
+
+	// Services served at their own paths by the same server, keyed by name.
+	// Each has its own MIME types, commands, auth and limits.
+	//
+	// required: false
+	Services map[string]*ServiceConfig `yaml:"services,omitempty"`
+
+	sourceClient     *http.Client
+	sourceClientOnce sync.Once
+	resolvers        map[string]SourceResolver
+	resolversOnce    sync.Once
+}
+
+// TLSConfig defines the options for serving HTTPS and verifying client certificates.
+//
+// swagger:model TLSConfig
+type TLSConfig struct {
+	// Path to the PEM encoded server certificate (and any intermediates).
+	// The file is reloaded when it changes on disk.
+	//
+	// required: true
+	CertFile string `yaml:"certFile"`
+
+	// Path to the PEM encoded private key for the server certificate.
+	// The file is reloaded when it changes on disk.
+	//
+	// required: true
+	KeyFile string `yaml:"keyFile"`
+
+	// Minimum TLS version to accept. One of "1.0", "1.1", "1.2" or "1.3".
+	//
+	// required: false
+	// default: 1.2
+	MinVersion string `yaml:"minVersion,omitempty"`
+
+	// Path to a PEM encoded CA bundle used to verify client certificates.
+	//
+	// required: false
+	ClientCAFile string `yaml:"clientCaFile,omitempty"`
+
+	// Client certificate policy. One of "none", "request", "verify-if-given" or "require".
+	// Defaults to "require" when clientCaFile is set, otherwise "none".
+	//
+	// required: false
+	ClientAuth string `yaml:"clientAuth,omitempty"`
+
+	// Client certificate subjects allowed to make requests.
+	// An entry matches either the full subject DN or the subject common name.
+	// If empty, any verified client certificate is allowed.
+	//
+	// required: false
+	AllowedClientSubjects []string `yaml:"allowedClientSubjects,omitempty"`
 }
 
 // Command describes the command and arguments to execute for a specific MIME type.
@@ -63,6 +187,20 @@ type Command struct {
 	// required: false
 	Args []string `yaml:"args"`
 
+	// MIME types the command can output, most preferred first.
+	// When set, the destination MIME type is negotiated from the Accept header against them,
+	// and requests accepting none of them are rejected with 406 Not Acceptable.
+	//
+	// required: false
+	OutputMimeTypes []string `yaml:"outputMimeTypes,omitempty"`
+
+	// Send a Content-Disposition header of this type, "inline" or "attachment",
+	// with a filename for the output: the source's filename with the output MIME type's extension.
+	// If not set, no Content-Disposition header is sent.
+	//
+	// required: false
+	ContentDisposition string `yaml:"contentDisposition,omitempty"`
+
 	// Allow insecure arguments from X-Islandora-Args header without escaping.
 	// When false (default), arguments are validated against a whitelist regex.
 	// When true, arguments are passed through without validation (DANGEROUS).
@@ -70,6 +208,112 @@ type Command struct {
 	// required: false
 	// default: false
 	AllowInsecureArgs bool `yaml:"allowInsecureArgs,omitempty"`
+
+	// Restrictions on the arguments from the X-Islandora-Args header.
+	//
+	// required: false
+	ArgPolicy *ArgPolicy `yaml:"argPolicy,omitempty"`
+
+	// Named argument lists X-Islandora-Args can select with preset:name,
+	// or the X-Islandora-Preset header with its name.
+	// Their arguments are trusted, so they aren't checked against argPolicy.
+	//
+	// required: false
+	Presets map[string][]string `yaml:"presets,omitempty"`
+
+	// Allow X-Islandora-Args to pass arguments other than presets.
+	//
+	// required: false
+	// default: true
+	AllowFreeformArgs *bool `yaml:"allowFreeformArgs,omitempty"`
+
+	// Render each argument as a Go text/template with the event payload as its data,
+	// instead of replacing special argument variables.
+	// Each argument renders to a single argument, which is left out if it renders empty.
+	// %args can still be used as an argument of its own.
+	//
+	// required: false
+	// default: false
+	ArgsTemplate bool `yaml:"argsTemplate,omitempty"`
+
+	// Rate limits for this command, applied on top of the global limits.
+	// They're counted separately from requests for other commands.
+	//
+	// required: false
+	RateLimit *RateLimitConfig `yaml:"rateLimit,omitempty"`
+
+	// Maximum size of the source in bytes.
+	// Larger sources are rejected with 413 Request Entity Too Large,
+	// up front when their size is known and otherwise once that much has been read.
+	//
+	// required: false
+	// default: 0 (unlimited)
+	MaxInputBytes int64 `yaml:"maxInputBytes,omitempty"`
+
+	// Maximum size of the command's output in bytes.
+	// The command is killed and the response fails once it writes more.
+	//
+	// required: false
+	// default: 0 (unlimited)
+	MaxOutputBytes int64 `yaml:"maxOutputBytes,omitempty"`
+
+	// Resource limits and isolation for the command.
+	//
+	// required: false
+	Sandbox *SandboxConfig `yaml:"sandbox,omitempty"`
+
+	// Environment variables for the command.
+	// When unset the command inherits the server's whole environment.
+	//
+	// required: false
+	Env *EnvConfig `yaml:"env,omitempty"`
+
+	// argTemplates are Args parsed by Validate when ArgsTemplate is set, nil for %args.
+	argTemplates []*template.Template
+}
+
+// Validate checks the command for configuration errors and parses its argument templates.
+func (c *Command) Validate() error {
+	if c.MaxInputBytes < 0 || c.MaxOutputBytes < 0 {
+		return errors.New("maxInputBytes and maxOutputBytes can't be negative")
+	}
+	if err := c.Sandbox.Validate(); err != nil {
+		return err
+	}
+	if err := c.Env.Validate(); err != nil {
+		return err
+	}
+	if err := c.ArgPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := c.validatePresets(); err != nil {
+		return err
+	}
+	if err := c.validateOutputMimeTypes(); err != nil {
+		return err
+	}
+	if c.ContentDisposition != "" && c.ContentDisposition != "inline" && c.ContentDisposition != "attachment" {
+		return fmt.Errorf("contentDisposition must be inline or attachment, not %q", c.ContentDisposition)
+	}
+	c.argTemplates = nil
+	if c.ArgsTemplate {
+		c.argTemplates = make([]*template.Template, len(c.Args))
+	}
+	for i, a := range c.Args {
+		if c.ArgsTemplate && a != "%args" {
+			t, err := parseArgTemplate(a)
+			if err != nil {
+				return err
+			}
+			c.argTemplates[i] = t
+			continue
+		}
+		if err := validatePlaceholders(a); err != nil {
+			return err
+		}
+	}
+
+	return c.RateLimit.Validate()
 }
 
 // IsAllowedMimeType checks if a given MIME type is allowed based on the configured formats.
@@ -99,140 +343,199 @@ func IsAllowedMimeType(mimetype string, allowedFormats []string) bool {
 
 // ReadConfig reads and parses the scyllaridae configuration from the environment.
 // It first checks the SCYLLARIDAE_YML environment variable for inline YAML content,
-// then falls back to reading from the file path specified in SCYLLARIDAE_YML_PATH.
-// Environment variables in the YAML content are expanded using os.ExpandEnv.
+// then falls back to reading the files listed in SCYLLARIDAE_YML_PATH, separated like PATH.
+// Each file is merged over the ones before it, and over the files it includes.
+// Environment variables and files referenced in the YAML content are expanded, see expandVariables.
 func ReadConfig() (*ServerConfig, error) {
 	var (
-		y   []byte
-		err error
+		root *yaml.Node
+		err  error
 	)
 	yml := os.Getenv("SCYLLARIDAE_YML")
 	if yml != "" {
-		y = []byte(yml)
+		root, err = parseConfig([]byte(yml), ".", nil)
 	} else {
 		yp := os.Getenv("SCYLLARIDAE_YML_PATH")
 		if yp == "" {
 			return nil, errors.New("need to specify the path to scyllaridae.yml with the environment variable SCYLLARIDAE_YML_PATH")
 		}
-		y, err = os.ReadFile(yp)
-		if err != nil {
+		slog.Debug("Reading scyllaridae yaml", "files", yp)
+		root, err = loadConfigFiles(filepath.SplitList(yp))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var c ServerConfig
+	if root != nil {
+		if err := root.Decode(&c); err != nil {
 			return nil, err
 		}
 	}
 
-	expanded := os.ExpandEnv(string(y))
-
-	slog.Debug("scyllaridae yaml set", "yaml", expanded)
-
-	var c ServerConfig
-	err = yaml.Unmarshal([]byte(expanded), &c)
-	if err != nil {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	if err := c.prepareServices(); err != nil {
 		return nil, err
 	}
 
+	return &c, nil
+}
+
+// prepare sets the config's defaults and checks it for configuration errors.
+func (c *ServerConfig) prepare() error {
 	if c.ForwardAuth == nil {
 		fa := true
 		c.ForwardAuth = &fa
 	}
 
-	return &c, nil
+	if err := c.SourcePolicy.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := newSourceClient(c.SourcePolicy, c.Fetch); err != nil {
+		return err
+	}
+
+	if err := c.Sources.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateStreamWrappers(c.StreamWrappers); err != nil {
+		return err
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateSubjectLimits(); err != nil {
+		return err
+	}
+	if err := c.Redact.Validate(); err != nil {
+		return err
+	}
+	for mimeType, cmd := range c.CmdByMimeType {
+		if err := cmd.Validate(); err != nil {
+			return fmt.Errorf("cmdByMimeType %s: %w", mimeType, err)
+		}
+		c.CmdByMimeType[mimeType] = cmd
+	}
+	for name, cmd := range c.Commands {
+		if err := cmd.Validate(); err != nil {
+			return fmt.Errorf("commands %s: %w", name, err)
+		}
+		c.Commands[name] = cmd
+	}
+
+	return c.validateRoutes()
+}
+
+// CoalesceRequests reports whether identical concurrent requests share a command's output.
+func (c *ServerConfig) CoalesceRequests() bool {
+	return c.Coalesce == nil || *c.Coalesce
+}
+
+// commandMimeType returns the MIME type used to select the message's command.
+func (c *ServerConfig) commandMimeType(message api.Payload) string {
+	if c.MimeTypeFromDestination {
+		return message.Attachment.Content.DestinationMimeType
+	}
+
+	return message.Attachment.Content.SourceMimeType
+}
+
+// CommandFor returns the key and command configured for the message.
+// The command of the first matching route is used, keyed by its name.
+// Otherwise the cmdByMimeType entry for the MIME type is used, trying the MIME type without parameters
+// and then its type wildcard, e.g. image/*, before falling back to default.
+func (c *ServerConfig) CommandFor(message api.Payload) (string, Command) {
+	if i := c.route(message); i >= 0 {
+		name := c.Routes[i].Command
+		return name, c.Commands[name]
+	}
+
+	mimeType := c.commandMimeType(message)
+	if cmdConfig, exists := c.CmdByMimeType[mimeType]; exists {
+		return mimeType, cmdConfig
+	}
+	base := baseMimeType(mimeType)
+	if cmdConfig, exists := c.CmdByMimeType[base]; exists {
+		return base, cmdConfig
+	}
+	if mediaType, _, ok := strings.Cut(base, "/"); ok {
+		if cmdConfig, exists := c.CmdByMimeType[mediaType+"/*"]; exists {
+			return mediaType + "/*", cmdConfig
+		}
+	}
+
+	slog.Debug("Using default command")
+	return "default", c.CmdByMimeType["default"]
 }
 
 // BuildExecCommand constructs an exec.Cmd based on the event payload and server configuration.
 // It selects the appropriate command based on MIME type and replaces special placeholder variables
-// in the arguments (e.g., %args, %source-uri, %destination-uri, %canonical).
+// in the arguments (e.g., %args, %source-uri, %destination-uri, %canonical), or renders them as templates with argsTemplate.
 func BuildExecCommand(message api.Payload, c *ServerConfig) (*exec.Cmd, error) {
-	slog.Debug("Building exec command", "msgId", message.Object.ID, "payloadType", message.Type, "target", message.Target)
+	_, cmdConfig := c.CommandFor(message)
 
-	mimeType := message.Attachment.Content.SourceMimeType
-	if c.MimeTypeFromDestination {
-		mimeType = message.Attachment.Content.DestinationMimeType
-	}
+	return BuildExecCommandFor(message, c, cmdConfig)
+}
+
+// BuildExecCommandFor constructs an exec.Cmd like BuildExecCommand, for a command that's already been selected.
+// Selecting the command again could pick a different one once the message has changed,
+// e.g. a route on destination MIME types after the output was negotiated.
+func BuildExecCommandFor(message api.Payload, c *ServerConfig, cmdConfig Command) (*exec.Cmd, error) {
+	slog.Debug("Building exec command", "msgId", message.Object.ID, "payloadType", message.Type, "target", message.Target)
 
+	mimeType := c.commandMimeType(message)
 	if mimeType != "" && !IsAllowedMimeType(mimeType, c.AllowedMimeTypes) {
 		return nil, fmt.Errorf("undefined mimeType to build command: %s", mimeType)
 	}
 
 	slog.Debug("Mapping mimetype to a command", "msgId", message.Object.ID, "mimeType", mimeType)
-
-	cmdConfig, exists := c.CmdByMimeType[mimeType]
-	if !exists {
-		slog.Debug("Using default command")
-		cmdConfig = c.CmdByMimeType["default"]
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Debug("Selecting command", "msgId", message.Object.ID, "explain", c.ExplainCommand(message))
 	}
 
 	args := []string{}
-	for _, a := range cmdConfig.Args {
+	for i, a := range cmdConfig.Args {
 		// if we have the special value of %args
 		// replace it with the args passed by the event
 		if a == "%args" {
-			if message.Attachment.Content.Args != "" {
-				passedArgs, err := GetPassedArgs(message.Attachment.Content.Args, cmdConfig.AllowInsecureArgs)
+			if message.Attachment.Content.Args != "" || message.Attachment.Content.Preset != "" {
+				passedArgs, err := cmdConfig.PassedArgs(message.Attachment.Content.Args, message.Attachment.Content.Preset)
 				if err != nil {
-					return nil, fmt.Errorf("could not parse args: %v", err)
+					return nil, fmt.Errorf("could not parse args: %w", err)
 				}
 				args = append(args, passedArgs...)
 			}
-			// if we have the special value of %source-mime-ext
-			// replace it with the source mimetype extension
-		} else if a == "%source-mime-ext" {
-			a, err := GetMimeTypeExtension(message.Attachment.Content.SourceMimeType)
-			if err != nil {
-				return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.SourceMimeType)
-			}
-
-			args = append(args, a)
-			// if we have the special value of %destination-mime-ext
-			// replace it with the source mimetype extension
-		} else if a == "%destination-mime-ext" || a == "%destination-mime-ext:-" {
-			dash := false
-			if a == "%destination-mime-ext:-" {
-				dash = true
-			}
-			a, err := GetMimeTypeExtension(message.Attachment.Content.DestinationMimeType)
-			if err != nil {
-				return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.DestinationMimeType)
-			}
-			if dash {
-				a = fmt.Sprintf("%s:-", a)
-			}
-			args = append(args, a)
-		} else if a == "%source-mime-pandoc" {
-			a, err := MimeToPandoc(message.Attachment.Content.SourceMimeType)
-			if err != nil {
-				return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.SourceMimeType)
-			}
+			continue
+		}
 
-			args = append(args, a)
-		} else if a == "%destination-mime-pandoc" {
-			a, err := MimeToPandoc(message.Attachment.Content.DestinationMimeType)
-			if err != nil {
-				return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.DestinationMimeType)
-			}
-			args = append(args, a)
-
-		} else if a == "%target" {
-			args = append(args, message.Target)
-		} else if a == "%source-uri" {
-			args = append(args, message.Attachment.Content.SourceURI)
-		} else if a == "%file-upload-uri" {
-			args = append(args, message.Attachment.Content.FileUploadURI)
-		} else if a == "%destination-uri" {
-			args = append(args, message.Attachment.Content.DestinationURI)
-		} else if a == "%canonical" {
-			for _, u := range message.Object.URL {
-				if u.Rel == "canonical" {
-					args = append(args, u.Href)
-					break
-				}
-			}
+		var values []string
+		var err error
+		if cmdConfig.ArgsTemplate {
+			values, err = cmdConfig.renderArgTemplate(i, message)
 		} else {
-			args = append(args, a)
+			values, err = expandVariable(a, message)
+		}
+		if err != nil {
+			return nil, err
 		}
+		args = append(args, values...)
 	}
 
 	cmd := exec.Command(cmdConfig.Cmd, args...)
-	cmd.Env = os.Environ()
+	env, err := cmdConfig.Env.environ(message)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = env
 	// pass the Authorization header as an environment variable to avoid logging it
 	if *c.ForwardAuth {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SCYLLARIDAE_AUTH=%s", message.Authorization))
@@ -241,6 +544,64 @@ func BuildExecCommand(message api.Payload, c *ServerConfig) (*exec.Cmd, error) {
 	return cmd, nil
 }
 
+// variableValue returns the value of the special variable a for message.
+func variableValue(a string, message api.Payload) ([]string, error) {
+	switch a {
+	// if we have the special value of %source-mime-ext
+	// replace it with the source mimetype extension
+	case "%source-mime-ext":
+		ext, err := GetMimeTypeExtension(message.Attachment.Content.SourceMimeType)
+		if err != nil {
+			return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.SourceMimeType)
+		}
+		return []string{ext}, nil
+	// if we have the special value of %destination-mime-ext
+	// replace it with the source mimetype extension
+	case "%destination-mime-ext", "%destination-mime-ext:-":
+		ext, err := GetMimeTypeExtension(message.Attachment.Content.DestinationMimeType)
+		if err != nil {
+			return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.DestinationMimeType)
+		}
+		if a == "%destination-mime-ext:-" {
+			ext = fmt.Sprintf("%s:-", ext)
+		}
+		return []string{ext}, nil
+	case "%source-mime-pandoc":
+		format, err := MimeToPandoc(message.Attachment.Content.SourceMimeType)
+		if err != nil {
+			return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.SourceMimeType)
+		}
+		return []string{format}, nil
+	case "%destination-mime-pandoc":
+		format, err := MimeToPandoc(message.Attachment.Content.DestinationMimeType)
+		if err != nil {
+			return nil, fmt.Errorf("unknown mime extension: %s", message.Attachment.Content.DestinationMimeType)
+		}
+		return []string{format}, nil
+	case "%source-mime-declared":
+		return []string{message.Attachment.Content.DeclaredSourceMimeType}, nil
+	case "%source-mime-detected":
+		return []string{message.Attachment.Content.DetectedSourceMimeType}, nil
+	case "%target":
+		return []string{message.Target}, nil
+	case "%source-uri":
+		return []string{message.Attachment.Content.SourceURI}, nil
+	case "%file-upload-uri":
+		return []string{message.Attachment.Content.FileUploadURI}, nil
+	case "%destination-uri":
+		return []string{message.Attachment.Content.DestinationURI}, nil
+	case "%canonical":
+		for _, u := range message.Object.URL {
+			if u.Rel == "canonical" {
+				return []string{u.Href}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	return []string{a}, nil
+}
+
 // GetMimeTypeExtension returns the file extension for a given MIME type.
 // It first checks a custom mapping for common MIME types, then falls back to
 // the standard library's mime.ExtensionsByType.
@@ -297,63 +658,9 @@ func GetMimeTypeExtension(mimeType string) (string, error) {
 // GetPassedArgs parses and validates command-line arguments from a string.
 // It uses shell-style parsing and validates each argument against a whitelist regex
 // to prevent command injection attacks, unless allowInsecure is true.
+// Commands with an argPolicy are checked with Command.PassedArgs instead.
 func GetPassedArgs(args string, allowInsecure bool) ([]string, error) {
-	passedArgs, err := shlex.Split(args)
-	if err != nil {
-		return nil, fmt.Errorf("error splitting args %s: %v", args, err)
-	}
-
-	// Skip validation if insecure args are allowed
-	if allowInsecure {
-		return passedArgs, nil
-	}
-
-	// make sure args are OK
-	regex, err := regexp.Compile(`^[a-zA-Z0-9._\-:\/@ =]+$`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compile regex: %v", err)
-	}
-	for _, value := range passedArgs {
-		if !regex.MatchString(value) {
-			return nil, fmt.Errorf("invalid input for passed arg: %s", value)
-		}
-	}
-
-	return passedArgs, nil
-}
-
-func (c *ServerConfig) GetFileStream(r *http.Request, message api.Payload, auth string) (io.ReadCloser, int, error) {
-	if r.Method == http.MethodPost {
-		slog.Debug("Streaming body directly to command", "msgId", message.Object.ID)
-		return r.Body, http.StatusOK, nil
-	}
-	if message.Attachment.Content.SourceURI == "" {
-		slog.Debug("No source URI to stream", "msgId", message.Object.ID)
-		return nil, http.StatusOK, nil
-	}
-
-	slog.Debug("Opening SourceURI for streaming", "msgId", message.Object.ID, "SourceURI", message.Attachment.Content.SourceURI)
-	req, err := http.NewRequest("GET", message.Attachment.Content.SourceURI, nil)
-	if err != nil {
-		slog.Error("Error building request to fetch source file contents", "err", err)
-		return nil, http.StatusBadRequest, fmt.Errorf("bad request")
-	}
-	if *c.ForwardAuth {
-		req.Header.Set("Authorization", auth)
-	}
-	sourceResp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		slog.Error("Error fetching source file contents", "err", err)
-		return nil, http.StatusInternalServerError, fmt.Errorf("internal error")
-	}
-	if sourceResp.StatusCode != http.StatusOK {
-		slog.Error("SourceURI sent a bad status code", "code", sourceResp.StatusCode, "uri", message.Attachment.Content.SourceURI)
-		return nil, http.StatusFailedDependency, fmt.Errorf("failed dependency")
-	}
-
-	slog.Debug("HTTP request created for source", "msgId", message.Object.ID, "url", req.URL.String())
-
-	return sourceResp.Body, http.StatusOK, nil
+	return Command{AllowInsecureArgs: allowInsecure}.PassedArgs(args, "")
 }
 
 // MimeToPandoc converts a MIME type to its corresponding Pandoc format string.
@@ -7,6 +7,7 @@ import (
 
 	"github.com/islandora/scyllaridae/pkg/api"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsAllowedMimeType(t *testing.T) {
@@ -63,6 +64,39 @@ func TestReadConfig(t *testing.T) {
 		wantError bool
 		validate  func(*testing.T, *ServerConfig)
 	}{
+		{
+			name: "per subject rate limit without jwksUri",
+			yml: `rateLimit:
+  perSubject:
+    requests: 10
+cmdByMimeType:
+  default:
+    cmd: "echo"`,
+			wantError: true,
+		},
+		{
+			name: "per subject command rate limit without jwksUri",
+			yml: `cmdByMimeType:
+  default:
+    cmd: "echo"
+    rateLimit:
+      perSubject:
+        requests: 10`,
+			wantError: true,
+		},
+		{
+			name: "per subject rate limit with jwksUri",
+			yml: `jwksUri: "https://example.com/keys"
+rateLimit:
+  perSubject:
+    requests: 10
+cmdByMimeType:
+  default:
+    cmd: "echo"`,
+			validate: func(t *testing.T, c *ServerConfig) {
+				assert.Equal(t, 10, c.RateLimit.PerSubject.Requests)
+			},
+		},
 		{
 			name: "valid config with defaults",
 			yml: `allowedMimeTypes:
@@ -113,6 +147,273 @@ cmdByMimeType:
 			yml:       "this is not: valid: yaml:",
 			wantError: true,
 		},
+		{
+			name: "command size limits",
+			yml: `cmdByMimeType:
+  default:
+    cmd: cat
+    maxInputBytes: 1048576
+    maxOutputBytes: 2097152`,
+			validate: func(t *testing.T, c *ServerConfig) {
+				assert.Equal(t, int64(1048576), c.CmdByMimeType["default"].MaxInputBytes)
+				assert.Equal(t, int64(2097152), c.CmdByMimeType["default"].MaxOutputBytes)
+			},
+		},
+		{
+			name: "command sandbox",
+			yml: `cmdByMimeType:
+  default:
+    cmd: cat
+    sandbox:
+      cpuSeconds: 60
+      memoryBytes: 536870912
+      uid: 65534
+      readOnly: true`,
+			validate: func(t *testing.T, c *ServerConfig) {
+				sandbox := c.CmdByMimeType["default"].Sandbox
+				assert.Equal(t, uint64(60), sandbox.CPUSeconds)
+				assert.Equal(t, int64(536870912), sandbox.MemoryBytes)
+				assert.Equal(t, 65534, *sandbox.UID)
+				assert.True(t, sandbox.ReadOnly)
+				assert.Equal(t, "/sys/fs/cgroup/scyllaridae", sandbox.Cgroup())
+			},
+		},
+		{
+			name: "relative sandbox cgroupParent",
+			yml: `cmdByMimeType:
+  default:
+    cmd: cat
+    sandbox:
+      cgroupParent: scyllaridae`,
+			wantError: true,
+		},
+		{
+			name: "invalid redact pattern",
+			yml: `redact:
+  patterns: ["password=("]
+cmdByMimeType:
+  default:
+    cmd: cat`,
+			wantError: true,
+		},
+		{
+			name: "unknown redact placeholder",
+			yml: `redact:
+  placeholders: ["%secret"]
+cmdByMimeType:
+  default:
+    cmd: cat`,
+			wantError: true,
+		},
+		{
+			name: "unknown placeholder",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    args: ["-", "out.%destination-mime", "-"]`,
+			wantError: true,
+		},
+		{
+			name: "argument templates",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    argsTemplate: true
+    args: ["-", "%args", "{{if .Object.IsNewVersion}}--new{{end}}", "{{.Attachment.Content.DestinationMimeType | mimeExt}}:-"]`,
+			wantError: false,
+			validate: func(t *testing.T, c *ServerConfig) {
+				// parsed once when loaded rather than on every request
+				templates := c.CmdByMimeType["default"].argTemplates
+				require.Len(t, templates, 4)
+				assert.NotNil(t, templates[0])
+				assert.Nil(t, templates[1])
+				assert.NotNil(t, templates[3])
+			},
+		},
+		{
+			name: "invalid argument template",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    argsTemplate: true
+    args: ["{{.Summary"]`,
+			wantError: true,
+		},
+		{
+			name: "unknown argument template function",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    argsTemplate: true
+    args: ["{{mimeExtension .Summary}}"]`,
+			wantError: true,
+		},
+		{
+			name: "argument policy",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    args: ["-", "%args", "-"]
+    argPolicy:
+      maxArgs: 4
+      allowedFlags:
+        "-quality": "[0-9]+"
+        "-strip": ""
+      forbiddenFlags: ["-write", "@"]`,
+			wantError: false,
+		},
+		{
+			name: "argument presets",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    args: ["-", "%args", "jpg:-"]
+    allowFreeformArgs: false
+    presets:
+      thumbnail: ["-thumbnail", "100x100>"]
+      service-file: ["-resize", "1600x1600>"]`,
+			wantError: false,
+		},
+		{
+			name: "routes",
+			yml: `routes:
+  - name: pdf-thumbnail
+    sourceMimeTypes: ["application/pdf"]
+    destinationMimeTypes: ["image/*"]
+    maxSourceBytes: 1000000
+    command: pdftoppm
+commands:
+  pdftoppm:
+    cmd: pdftoppm
+cmdByMimeType:
+  "image/*":
+    cmd: convert`,
+			wantError: false,
+		},
+		{
+			name: "route to an unknown command",
+			yml: `routes:
+  - sourceMimeTypes: ["application/pdf"]
+    command: pdftoppm
+cmdByMimeType:
+  pdftoppm:
+    cmd: pdftoppm`,
+			wantError: true,
+		},
+		{
+			name: "invalid route regex",
+			yml: `routes:
+  - summary: "(ocr"
+    command: tesseract
+commands:
+  tesseract:
+    cmd: tesseract`,
+			wantError: true,
+		},
+		{
+			name: "command named like a cmdByMimeType key",
+			yml: `commands:
+  default:
+    cmd: cat
+cmdByMimeType:
+  default:
+    cmd: cat`,
+			wantError: true,
+		},
+		{
+			name: "invalid argument policy",
+			yml: `cmdByMimeType:
+  default:
+    cmd: convert
+    argPolicy:
+      allowedFlags:
+        quality: "[0-9]+"`,
+			wantError: true,
+		},
+		{
+			name: "services",
+			yml: `redact:
+  queryParams: ["sig"]
+services:
+  houdini:
+    allowedMimeTypes: ["image/*"]
+    jwksUri: "https://example.com/keys"
+    cmdByMimeType:
+      default:
+        cmd: convert
+  homarus:
+    path: /video/
+    forwardAuth: false
+    cmdByMimeType:
+      default:
+        cmd: ffmpeg`,
+			wantError: false,
+			validate: func(t *testing.T, c *ServerConfig) {
+				assert.False(t, c.Serves())
+				require.Len(t, c.Services, 2)
+				houdini := c.Services["houdini"]
+				assert.Equal(t, "/houdini", houdini.Path)
+				assert.Equal(t, "https://example.com/keys", houdini.JwksUri)
+				assert.True(t, *houdini.ForwardAuth)
+				assert.Same(t, c.Redact, houdini.Redact)
+				homarus := c.Services["homarus"]
+				assert.Equal(t, "/video", homarus.Path)
+				assert.False(t, *homarus.ForwardAuth)
+				assert.Equal(t, "ffmpeg", homarus.CmdByMimeType["default"].Cmd)
+			},
+		},
+		{
+			name: "service at a reserved path",
+			yml: `services:
+  metrics:
+    cmdByMimeType:
+      default:
+        cmd: cat`,
+			wantError: true,
+		},
+		{
+			name: "services at the same path",
+			yml: `services:
+  houdini:
+    cmdByMimeType:
+      default:
+        cmd: convert
+  images:
+    path: /houdini
+    cmdByMimeType:
+      default:
+        cmd: convert`,
+			wantError: true,
+		},
+		{
+			name: "service with server-wide options",
+			yml: `services:
+  houdini:
+    cache:
+      dir: /tmp/cache
+    cmdByMimeType:
+      default:
+        cmd: convert`,
+			wantError: true,
+		},
+		{
+			name: "invalid service command",
+			yml: `services:
+  houdini:
+    cmdByMimeType:
+      default:
+        cmd: convert
+        maxInputBytes: -1`,
+			wantError: true,
+		},
+		{
+			name: "negative size limit",
+			yml: `cmdByMimeType:
+  default:
+    cmd: cat
+    maxOutputBytes: -1`,
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// EnvConfig defines the environment a command runs with.
+// Without it the command inherits the server's whole environment.
+//
+// swagger:model EnvConfig
+type EnvConfig struct {
+	// Names of the server's environment variables the command inherits.
+	// A trailing * matches every variable starting with the rest of the name, e.g. MAGICK_*.
+	//
+	// required: false
+	Inherit []string `yaml:"inherit,omitempty"`
+
+	// Environment variables to set for the command.
+	// Values can contain the same placeholders as arguments, e.g. %canonical.
+	//
+	// required: false
+	Set map[string]string `yaml:"set,omitempty"`
+}
+
+// Validate checks the environment for configuration errors.
+func (e *EnvConfig) Validate() error {
+	if e == nil {
+		return nil
+	}
+	for _, name := range e.Inherit {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid env inherit name %q", name)
+		}
+	}
+	for name, value := range e.Set {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid env variable name %q", name)
+		}
+		if value == "%args" {
+			return errors.New("env variables can't be set to %args")
+		}
+		if err := validatePlaceholders(value); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// inherits reports whether the command inherits the server's environment variable name.
+func (e *EnvConfig) inherits(name string) bool {
+	for _, pattern := range e.Inherit {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// environ returns the environment the command runs with for message.
+func (e *EnvConfig) environ(message api.Payload) ([]string, error) {
+	if e == nil {
+		return os.Environ(), nil
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, set := e.Set[name]; !set && e.inherits(name) {
+			env = append(env, kv)
+		}
+	}
+
+	names := make([]string, 0, len(e.Set))
+	for name := range e.Set {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		values, err := expandVariable(e.Set[name], message)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+		// variables without a value in the event, e.g. no canonical URL, are left unset
+		if len(values) > 0 {
+			env = append(env, name+"="+values[0])
+		}
+	}
+
+	return env, nil
+}
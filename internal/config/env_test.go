@@ -0,0 +1,120 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildExecCommand_Env(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://secret")
+	t.Setenv("MAGICK_THREAD_LIMIT", "2")
+	t.Setenv("LANG", "C.UTF-8")
+
+	message := api.Payload{Authorization: "Bearer token", Target: "thumbnail"}
+	message.Object.URL = []api.Link{{Rel: "canonical", Href: "https://example.com/node/1"}}
+	noCanonical := message
+	noCanonical.Object.URL = nil
+
+	tests := []struct {
+		name        string
+		env         *EnvConfig
+		forwardAuth bool
+		message     api.Payload
+		want        []string
+		notWant     []string
+	}{
+		{
+			name:        "whole environment without env",
+			forwardAuth: true,
+			message:     message,
+			want:        []string{"DATABASE_URL=postgres://secret", "LANG=C.UTF-8", "SCYLLARIDAE_AUTH=Bearer token"},
+		},
+		{
+			name: "allowlist and values",
+			env: &EnvConfig{
+				Inherit: []string{"LANG", "MAGICK_*"},
+				Set: map[string]string{
+					"LANG":             "en_CA.UTF-8",
+					"OMP_THREAD_LIMIT": "1",
+					"NODE_URL":         "%canonical",
+					"TARGET":           "%target",
+				},
+			},
+			forwardAuth: true,
+			message:     message,
+			want: []string{
+				"MAGICK_THREAD_LIMIT=2",
+				"LANG=en_CA.UTF-8",
+				"NODE_URL=https://example.com/node/1",
+				"OMP_THREAD_LIMIT=1",
+				"TARGET=thumbnail",
+				"SCYLLARIDAE_AUTH=Bearer token",
+			},
+			notWant: []string{"DATABASE_URL=postgres://secret", "LANG=C.UTF-8"},
+		},
+		{
+			name:    "no auth without forwardAuth",
+			env:     &EnvConfig{Inherit: []string{"LANG"}},
+			message: message,
+			want:    []string{"LANG=C.UTF-8"},
+			notWant: []string{"SCYLLARIDAE_AUTH=Bearer token"},
+		},
+		{
+			name:    "variables missing from the event are unset",
+			env:     &EnvConfig{Set: map[string]string{"NODE_URL": "%canonical"}},
+			message: noCanonical,
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ServerConfig{
+				ForwardAuth:      &tt.forwardAuth,
+				AllowedMimeTypes: []string{"*"},
+				CmdByMimeType: map[string]Command{
+					"default": {Cmd: "cat", Env: tt.env},
+				},
+			}
+
+			cmd, err := BuildExecCommand(tt.message, c)
+			require.NoError(t, err)
+			if tt.env == nil {
+				assert.Subset(t, cmd.Env, tt.want)
+				return
+			}
+			assert.Equal(t, tt.want, cmd.Env)
+			for _, kv := range tt.notWant {
+				assert.NotContains(t, cmd.Env, kv)
+			}
+		})
+	}
+}
+
+func TestEnvConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     *EnvConfig
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "valid", env: &EnvConfig{Inherit: []string{"PATH", "MAGICK_*"}, Set: map[string]string{"NODE_URL": "%canonical"}}},
+		{name: "empty inherit name", env: &EnvConfig{Inherit: []string{""}}, wantErr: true},
+		{name: "invalid name", env: &EnvConfig{Set: map[string]string{"A=B": "c"}}, wantErr: true},
+		{name: "args", env: &EnvConfig{Set: map[string]string{"ARGS": "%args"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.env.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultMaxRedirects   = 10
+	defaultRetryBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultUserAgent      = "scyllaridae"
+)
+
+// FetchConfig defines how the HTTP client fetching source URIs behaves.
+//
+// swagger:model FetchConfig
+type FetchConfig struct {
+	// Maximum time to wait for a connection to the source to be established.
+	//
+	// required: false
+	// default: 30s
+	ConnectTimeout time.Duration `yaml:"connectTimeout,omitempty"`
+
+	// Maximum time to wait for the source's response headers after sending the request.
+	// If zero, there is no limit.
+	//
+	// required: false
+	ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout,omitempty"`
+
+	// Maximum time for the whole request, including reading the response body
+	// and any resumed downloads. If zero, there is no limit.
+	//
+	// required: false
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// URL of an HTTP proxy to send source requests through.
+	// If empty, source requests are made directly.
+	//
+	// required: false
+	Proxy string `yaml:"proxy,omitempty"`
+
+	// Path to a PEM encoded CA bundle trusted in addition to the system CAs.
+	//
+	// required: false
+	CAFile string `yaml:"caFile,omitempty"`
+
+	// Maximum number of redirects to follow. Set to 0 to not follow redirects.
+	//
+	// required: false
+	// default: 10
+	MaxRedirects *int `yaml:"maxRedirects,omitempty"`
+
+	// User-Agent header sent with source requests.
+	//
+	// required: false
+	// default: scyllaridae
+	UserAgent string `yaml:"userAgent,omitempty"`
+
+	// Number of times to retry a source request that failed to connect or returned a 5xx status.
+	//
+	// required: false
+	// default: 0
+	Retries int `yaml:"retries,omitempty"`
+
+	// Time to wait before the first retry. Doubles after each retry.
+	//
+	// required: false
+	// default: 500ms
+	RetryBackoff time.Duration `yaml:"retryBackoff,omitempty"`
+
+	// Maximum time to wait between retries.
+	//
+	// required: false
+	// default: 10s
+	MaxRetryBackoff time.Duration `yaml:"maxRetryBackoff,omitempty"`
+
+	// Number of times to resume a source download after its connection drops, using HTTP Range requests.
+	// Downloads are only resumed if the source reports an ETag or Last-Modified
+	// that shows the source hasn't changed since the download started.
+	// Waits between attempts like retryBackoff.
+	//
+	// required: false
+	// default: 0
+	ResumeRetries int `yaml:"resumeRetries,omitempty"`
+}
+
+// newSourceClient creates the HTTP client used to fetch source URIs.
+func newSourceClient(p *SourcePolicy, f *FetchConfig) (*http.Client, error) {
+	if f == nil {
+		f = &FetchConfig{}
+	}
+
+	transport, err := newFetchTransport(f, p.dialContext)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRedirects := defaultMaxRedirects
+	if f.MaxRedirects != nil {
+		maxRedirects = *f.MaxRedirects
+	}
+
+	return &http.Client{
+		Timeout: f.Timeout,
+		Transport: &policyTransport{
+			policy:  p,
+			proxied: f.Proxy != "",
+			next: &fetchTransport{
+				config: f,
+				next:   transport,
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if maxRedirects == 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// newFetchTransport creates a transport with the fetch config's timeouts, proxy and CA bundle.
+// dial returns the function used to connect, given the address of the configured proxy.
+func newFetchTransport(f *FetchConfig, dial func(dialer *net.Dialer, proxyAddr string) func(ctx context.Context, network, address string) (net.Conn, error)) (*http.Transport, error) {
+	connectTimeout := f.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = f.ResponseHeaderTimeout
+	// never pick up a proxy from the environment:
+	// it would make the connection on our behalf, bypassing the address checks
+	transport.Proxy = nil
+	proxyAddr := ""
+	if f.Proxy != "" {
+		proxyURL, err := url.Parse(f.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid fetch proxy %q", f.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		proxyAddr = canonicalAddr(proxyURL)
+	}
+	transport.DialContext = dial(dialer, proxyAddr)
+
+	if f.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read fetch CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", f.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+// canonicalAddr returns the host:port the transport dials for u.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// fetchTransport sets the User-Agent and retries failed idempotent requests.
+type fetchTransport struct {
+	config *FetchConfig
+	next   http.RoundTripper
+}
+
+func (t *fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	userAgent := t.config.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	retries := t.config.Retries
+	// requests with a body can't be replayed
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		retries = 0
+	}
+
+	backoff := t.config.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := t.config.MaxRetryBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= retries || !retryable(resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			slog.Warn("Source returned a server error, retrying", "status", resp.StatusCode, "attempt", attempt+1, "backoff", backoff)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			slog.Warn("Unable to reach source, retrying", "err", err, "attempt", attempt+1, "backoff", backoff)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrSourceNotAllowed)
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// SourceClient returns the HTTP client used to fetch source URIs.
+// The client enforces the configured source policy on every connection and redirect.
+// Through a proxy, the host's addresses are resolved and checked before each request instead,
+// though the proxy resolves the host again itself when it connects.
+// Since requests to hosts outside the policy aren't sent, a forwarded
+// Authorization header can't be sent to them either.
+func (c *ServerConfig) SourceClient() *http.Client {
+	c.sourceClientOnce.Do(func() {
+		client, err := newSourceClient(c.SourcePolicy, c.Fetch)
+		if err != nil {
+			// ReadConfig rejects invalid fetch configs, so this only happens for configs built in code
+			slog.Error("Unable to configure source client, using defaults", "err", err)
+			client, _ = newSourceClient(c.SourcePolicy, nil)
+		}
+		c.sourceClient = client
+	})
+
+	return c.sourceClient
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var loopbackPolicy = &SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}}
+
+func TestReadConfig_Fetch(t *testing.T) {
+	os.Setenv("SCYLLARIDAE_YML", `
+fetch:
+  connectTimeout: 5s
+  responseHeaderTimeout: 1m
+  maxRedirects: 0
+  userAgent: "islandora-derivatives"
+  retries: 3
+  retryBackoff: 250ms
+cmdByMimeType:
+  default:
+    cmd: cat
+`)
+	defer os.Unsetenv("SCYLLARIDAE_YML")
+
+	c, err := ReadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, c.Fetch.ConnectTimeout)
+	assert.Equal(t, time.Minute, c.Fetch.ResponseHeaderTimeout)
+	assert.Equal(t, 0, *c.Fetch.MaxRedirects)
+	assert.Equal(t, "islandora-derivatives", c.Fetch.UserAgent)
+	assert.Equal(t, 3, c.Fetch.Retries)
+	assert.Equal(t, 250*time.Millisecond, c.Fetch.RetryBackoff)
+}
+
+func TestNewSourceClient_InvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name  string
+		fetch *FetchConfig
+	}{
+		{
+			name:  "proxy without host",
+			fetch: &FetchConfig{Proxy: "proxy:3128"},
+		},
+		{
+			name:  "missing CA bundle",
+			fetch: &FetchConfig{CAFile: filepath.Join(dir, "missing.pem")},
+		},
+		{
+			name:  "CA bundle without certificates",
+			fetch: &FetchConfig{CAFile: notPEM},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSourceClient(nil, tt.fetch)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSourceClient_Retries(t *testing.T) {
+	var attempts atomic.Int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "islandora-derivatives", r.UserAgent())
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("foo"))
+	}))
+	defer source.Close()
+
+	tests := []struct {
+		name         string
+		retries      int
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{
+			name:         "gives up after retries are exhausted",
+			retries:      1,
+			wantStatus:   http.StatusFailedDependency,
+			wantAttempts: 2,
+		},
+		{
+			name:         "succeeds after retrying",
+			retries:      5,
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts.Store(0)
+			fa := false
+			c := &ServerConfig{
+				ForwardAuth:  &fa,
+				SourcePolicy: loopbackPolicy,
+				Fetch: &FetchConfig{
+					UserAgent:    "islandora-derivatives",
+					Retries:      tt.retries,
+					RetryBackoff: time.Millisecond,
+				},
+			}
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = source.URL
+			req := httptest.NewRequest("GET", "/", nil)
+
+			fs, status, _ := c.OpenSource(req, message, "")
+			if fs != nil {
+				fs.Close()
+			}
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestSourceClient_ConnectionError(t *testing.T) {
+	// grab a free port then close it so nothing is listening
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	fa := false
+	c := &ServerConfig{
+		ForwardAuth:  &fa,
+		SourcePolicy: loopbackPolicy,
+		Fetch: &FetchConfig{
+			Retries:      2,
+			RetryBackoff: time.Millisecond,
+		},
+	}
+	message := api.Payload{}
+	message.Attachment.Content.SourceURI = "http://" + addr
+	req := httptest.NewRequest("GET", "/", nil)
+
+	_, status, err := c.OpenSource(req, message, "")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestSourceClient_MaxRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	tests := []struct {
+		name         string
+		maxRedirects int
+		wantStatus   int
+	}{
+		{
+			name:         "redirects disabled",
+			maxRedirects: 0,
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:         "redirect followed",
+			maxRedirects: 1,
+			wantStatus:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ServerConfig{
+				SourcePolicy: loopbackPolicy,
+				Fetch:        &FetchConfig{MaxRedirects: &tt.maxRedirects},
+			}
+			resp, err := c.SourceClient().Get(redirect.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestSourceClient_Proxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name    string
+		policy  *SourcePolicy
+		url     string
+		wantErr bool
+	}{
+		{name: "link-local metadata address", url: "http://169.254.169.254/latest/meta-data/", wantErr: true},
+		{name: "loopback", url: "http://127.0.0.1:8080/", wantErr: true},
+		{name: "host resolving to loopback", url: "http://localhost/", wantErr: true},
+		{name: "address outside allowedCidrs", policy: &SourcePolicy{AllowedCIDRs: []string{"10.0.0.0/8"}}, url: "http://192.0.2.1/", wantErr: true},
+		{name: "allowed address", policy: &SourcePolicy{AllowedCIDRs: []string{"192.0.2.0/24"}}, url: "http://192.0.2.1/"},
+		{name: "public address", url: "http://192.0.2.1/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxied.Store(0)
+			c := &ServerConfig{SourcePolicy: tt.policy, Fetch: &FetchConfig{Proxy: proxy.URL}}
+			resp, err := c.SourceClient().Get(tt.url)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrSourceNotAllowed)
+				assert.Zero(t, proxied.Load(), "the request shouldn't reach the proxy")
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.EqualValues(t, 1, proxied.Load())
+		})
+	}
+}
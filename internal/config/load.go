@@ -0,0 +1,328 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// includeKey lists the files a config file is layered on top of.
+const includeKey = "include"
+
+// configFile is what a config file can have: the top-level config and the files it includes.
+type configFile struct {
+	Include      any `yaml:"include"`
+	ServerConfig `yaml:",inline"`
+}
+
+// loadConfigFiles reads the config files, merging each one over the ones before it.
+func loadConfigFiles(paths []string) (*yaml.Node, error) {
+	var merged *yaml.Node
+	for _, path := range paths {
+		node, err := loadConfigFile(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeNodes(merged, node)
+	}
+
+	return merged, nil
+}
+
+// loadConfigFile reads a config file merged over the files it includes.
+// stack has the files including it, to catch include cycles.
+func loadConfigFile(path string, stack []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, abs) {
+		return nil, fmt.Errorf("%s includes itself", path)
+	}
+
+	y, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	node, err := parseConfig(y, filepath.Dir(abs), append(slices.Clip(stack), abs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return node, nil
+}
+
+// parseConfig parses config YAML, expanding its variables and merging it over the files it includes.
+// Relative includes are read from dir.
+func parseConfig(y []byte, dir string, stack []string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(y, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return nil, nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("config must be a map")
+	}
+	if err := expandNode(root); err != nil {
+		return nil, err
+	}
+	if err := checkConfig(root); err != nil {
+		return nil, err
+	}
+
+	includes, err := takeIncludes(root)
+	if err != nil {
+		return nil, err
+	}
+	var merged *yaml.Node
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+		node, err := loadConfigFile(include, stack)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeNodes(merged, node)
+	}
+
+	return mergeNodes(merged, root), nil
+}
+
+// checkConfig decodes a config file's map strictly, so keys that aren't config options,
+// like misspelled ones, fail with their line number instead of being ignored.
+func checkConfig(root *yaml.Node) error {
+	errs := unknownFields(root, reflect.TypeFor[configFile]())
+	var f configFile
+	if err := root.Decode(&f); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		errs = append(errs, typeErr.Errors...)
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+
+	return nil
+}
+
+// unknownFields returns an error for every key in the YAML node n that isn't a field of the type t,
+// or of the types it holds, like the errors of a decoder with KnownFields.
+// yaml.Node.Decode can't be made strict, so the keys are checked separately.
+func unknownFields(n *yaml.Node, t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var errs []string
+	switch {
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, t))
+				continue
+			}
+			errs = append(errs, unknownFields(value, field)...)
+		}
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(n.Content); i += 2 {
+			errs = append(errs, unknownFields(n.Content[i], t.Elem())...)
+		}
+	case n.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, item := range n.Content {
+			errs = append(errs, unknownFields(item, t.Elem())...)
+		}
+	}
+
+	return errs
+}
+
+// yamlFields returns the types of the struct type t's fields by their YAML keys, including those of inlined structs.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(opts, ","), "inline") {
+			maps.Copy(fields, yamlFields(f.Type))
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// takeIncludes removes the include key from a config map, returning the files it lists.
+func takeIncludes(root *yaml.Node) ([]string, error) {
+	i := mapIndex(root, includeKey)
+	if i < 0 {
+		return nil, nil
+	}
+	value := root.Content[i+1]
+	root.Content = slices.Delete(root.Content, i, i+2)
+
+	var includes []string
+	if value.Kind == yaml.ScalarNode {
+		includes = []string{value.Value}
+	} else if err := value.Decode(&includes); err != nil {
+		return nil, fmt.Errorf("include must be a file or a list of files: %w", err)
+	}
+
+	return includes, nil
+}
+
+// mapIndex returns the index of key in the YAML map m's content, or -1 if it doesn't have it.
+func mapIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// mergeNodes merges the YAML node src over dst.
+// Maps are merged key by key, anything else in src, including lists, replaces what's in dst.
+// A key set to null in src is removed.
+func mergeNodes(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil {
+		return src
+	}
+	if src == nil {
+		return dst
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+
+	merged := *dst
+	merged.Content = slices.Clone(dst.Content)
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		j := mapIndex(&merged, key.Value)
+		switch {
+		case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+			if j >= 0 {
+				merged.Content = slices.Delete(merged.Content, j, j+2)
+			}
+		case j < 0:
+			merged.Content = append(merged.Content, key, value)
+		default:
+			merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+		}
+	}
+
+	return &merged
+}
+
+// expandNode expands the variables in the values of the YAML node n, see expandVariables.
+// Keys aren't expanded. Unquoted values are resolved again once they're expanded,
+// so e.g. ${MAX_INPUT_BYTES} can be a number.
+func expandNode(n *yaml.Node) error {
+	var errs []error
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			errs = append(errs, expandNode(n.Content[i]))
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			errs = append(errs, expandNode(item))
+		}
+	case yaml.ScalarNode:
+		expanded, err := expandVariables(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		if expanded != n.Value && n.Style == 0 {
+			// the tag was resolved from the unexpanded text, so let it be
+			// resolved again from the value, e.g. "${WORKERS}" as an int
+			n.Tag = ""
+		}
+		n.Value = expanded
+	}
+
+	return errors.Join(errs...)
+}
+
+// expandVariables replaces the variables in a config value:
+// $VAR and ${VAR} with the environment variable,
+// ${VAR:-default} with a default for when it's unset or empty,
+// ${VAR:?message} failing with the message when it's unset or empty,
+// and ${file:/path} with the contents of a file, without trailing newlines.
+func expandVariables(s string) (string, error) {
+	var errs []error
+	expanded := os.Expand(s, func(name string) string {
+		value, err := variable(name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return value
+	})
+
+	return expanded, errors.Join(errs...)
+}
+
+// variable returns the value of a variable in config YAML, without its $ and braces.
+func variable(name string) (string, error) {
+	if path, ok := strings.CutPrefix(name, "file:"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %w", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	i := strings.Index(name, ":")
+	if i < 0 || i+1 == len(name) {
+		return os.Getenv(name), nil
+	}
+	env, op, arg := name[:i], name[i+1], name[i+2:]
+	value := os.Getenv(env)
+	switch op {
+	case '-':
+		if value == "" {
+			return arg, nil
+		}
+	case '?':
+		if value == "" {
+			if arg == "" {
+				arg = "not set"
+			}
+			return "", fmt.Errorf("%s: %s", env, arg)
+		}
+	default:
+		return os.Getenv(name), nil
+	}
+
+	return value, nil
+}
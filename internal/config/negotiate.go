@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// validateOutputMimeTypes checks the command's output MIME types are MIME types rather than ranges.
+func (c Command) validateOutputMimeTypes() error {
+	for _, mimeType := range c.OutputMimeTypes {
+		base, _, err := mime.ParseMediaType(mimeType)
+		if err != nil || !strings.Contains(base, "/") || strings.Contains(base, "*") {
+			return fmt.Errorf("invalid outputMimeTypes MIME type %q", mimeType)
+		}
+	}
+
+	return nil
+}
+
+// NegotiateOutput returns the output MIME type of the command the Accept header prefers,
+// or false if it doesn't accept any of them.
+// Each output MIME type gets the quality of the most specific media range matching it.
+// Ties go to the MIME type matched by a more specific range, then to the one listed first in outputMimeTypes.
+// An empty Accept header accepts any MIME type, so the first is used.
+// Commands without output MIME types can output anything, so there's nothing to negotiate and it returns "".
+func (c Command) NegotiateOutput(accept string) (string, bool) {
+	if len(c.OutputMimeTypes) == 0 {
+		return "", true
+	}
+	if strings.TrimSpace(accept) == "" {
+		return c.OutputMimeTypes[0], true
+	}
+
+	ranges := api.ParseAccept(accept)
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, mimeType := range c.OutputMimeTypes {
+		q, specificity := quality(mimeType, ranges)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = mimeType, q, specificity
+		}
+	}
+
+	return best, best != ""
+}
+
+// quality returns the quality the media ranges give mimeType, from the most specific range matching it,
+// and how specific that range is. It's 0 and -1 when no range matches.
+func quality(mimeType string, ranges []api.MediaRange) (float64, int) {
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		if s := r.Specificity(); s > specificity && matchMimeType(r.MimeType, mimeType) {
+			q, specificity = r.Q, s
+		}
+	}
+
+	return q, specificity
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateOutput(t *testing.T) {
+	image := Command{OutputMimeTypes: []string{"image/jpeg", "image/png", "image/webp"}}
+	text := Command{OutputMimeTypes: []string{"text/plain; charset=utf-8", "text/html"}}
+
+	tests := []struct {
+		name    string
+		command Command
+		accept  string
+		want    string
+		wantOK  bool
+	}{
+		{name: "no output MIME types", command: Command{}, accept: "image/jpeg", want: "", wantOK: true},
+		{name: "empty Accept", command: image, accept: "", want: "image/jpeg", wantOK: true},
+		{name: "exact match", command: image, accept: "image/webp", want: "image/webp", wantOK: true},
+		{name: "highest quality wins", command: image, accept: "image/jpeg;q=0.5, image/png;q=0.8, */*;q=0.1", want: "image/png", wantOK: true},
+		{name: "wildcard uses first output", command: image, accept: "*/*", want: "image/jpeg", wantOK: true},
+		{name: "type wildcard", command: image, accept: "text/*, image/*;q=0.5", want: "image/jpeg", wantOK: true},
+		{name: "explicit type beats wildcard of same quality", command: image, accept: "image/*, image/webp", want: "image/webp", wantOK: true},
+		{name: "most specific range sets quality", command: image, accept: "image/*, image/jpeg;q=0", want: "image/png", wantOK: true},
+		{name: "q=0 excludes", command: image, accept: "image/jpeg;q=0, image/png;q=0", want: "", wantOK: false},
+		{name: "nothing acceptable", command: image, accept: "application/pdf, text/*", want: "", wantOK: false},
+		{name: "output parameters", command: text, accept: "text/plain", want: "text/plain; charset=utf-8", wantOK: true},
+		{name: "range parameters must match", command: text, accept: "text/plain; charset=iso-8859-1, text/html;q=0.5", want: "text/html", wantOK: true},
+		{name: "unparseable Accept", command: image, accept: "jpeg", want: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.command.NegotiateOutput(tt.accept)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateOutputMimeTypes(t *testing.T) {
+	assert.NoError(t, Command{OutputMimeTypes: []string{"image/jpeg", "text/plain; charset=utf-8"}}.validateOutputMimeTypes())
+	for _, mimeType := range []string{"image/*", "*/*", "jpeg", ""} {
+		assert.Error(t, Command{OutputMimeTypes: []string{mimeType}}.validateOutputMimeTypes(), mimeType)
+	}
+}
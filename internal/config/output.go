@@ -0,0 +1,43 @@
+package config
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// defaultOutputName names the output of sources without a filename, like uploads.
+const defaultOutputName = "output"
+
+// OutputFilename returns a filename for the output of mimeType made from the message's source:
+// the source's filename with the MIME type's extension, e.g. a.tiff output as image/jpeg is a.jpg.
+// The extension is left off when the MIME type doesn't have one.
+func OutputFilename(message api.Payload, mimeType string) string {
+	name := ""
+	if u, err := url.Parse(message.Attachment.Content.SourceURI); err == nil {
+		name = path.Base(u.Path)
+	}
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "." || name == "/" {
+		name = defaultOutputName
+	}
+
+	if ext, err := GetMimeTypeExtension(mimeType); err == nil && ext != "" {
+		name += "." + ext
+	}
+
+	return name
+}
+
+// ContentDispositionFor returns the Content-Disposition header for the command's output of mimeType,
+// or "" if the command doesn't send one.
+func (c Command) ContentDispositionFor(message api.Payload, mimeType string) string {
+	if c.ContentDisposition == "" {
+		return ""
+	}
+
+	return mime.FormatMediaType(c.ContentDisposition, map[string]string{"filename": OutputFilename(message, mimeType)})
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFilename(t *testing.T) {
+	tests := []struct {
+		name      string
+		sourceURI string
+		mimeType  string
+		want      string
+	}{
+		{name: "http source", sourceURI: "https://example.com/sites/default/files/2024-01/scan.tiff", mimeType: "image/webp", want: "scan.webp"},
+		{name: "escaped filename", sourceURI: "https://example.com/files/my%20scan.tiff?itok=abc", mimeType: "application/pdf", want: "my scan.pdf"},
+		{name: "stream wrapper", sourceURI: "private://2024-01/book.pdf", mimeType: "image/webp", want: "book.webp"},
+		{name: "no extension on source", sourceURI: "s3://bucket/objects/1234", mimeType: "application/pdf", want: "1234.pdf"},
+		{name: "upload", sourceURI: "", mimeType: "application/pdf", want: "output.pdf"},
+		{name: "data URI", sourceURI: "data:text/plain,hello", mimeType: "application/pdf", want: "output.pdf"},
+		{name: "directory", sourceURI: "https://example.com/", mimeType: "image/webp", want: "output.webp"},
+		{name: "unknown MIME type", sourceURI: "https://example.com/scan.tiff", mimeType: "application/x-unknown", want: "scan"},
+		{name: "no MIME type", sourceURI: "https://example.com/scan.tiff", mimeType: "", want: "scan"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message api.Payload
+			message.Attachment.Content.SourceURI = tt.sourceURI
+			assert.Equal(t, tt.want, OutputFilename(message, tt.mimeType))
+		})
+	}
+}
+
+func TestContentDispositionFor(t *testing.T) {
+	var message api.Payload
+	message.Attachment.Content.SourceURI = "https://example.com/files/scan%C3%A9.tiff"
+
+	assert.Equal(t, "", Command{}.ContentDispositionFor(message, "image/webp"))
+	assert.Equal(t, "inline; filename*=utf-8''scan%C3%A9.webp", Command{ContentDisposition: "inline"}.ContentDispositionFor(message, "image/webp"))
+
+	message.Attachment.Content.SourceURI = "https://example.com/files/scan.tiff"
+	assert.Equal(t, "attachment; filename=scan.webp", Command{ContentDisposition: "attachment"}.ContentDispositionFor(message, "image/webp"))
+
+	assert.NoError(t, (&Command{ContentDisposition: "attachment"}).Validate())
+	assert.Error(t, (&Command{ContentDisposition: "download"}).Validate())
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// specialVariables are the placeholders command arguments can have, other than %args.
+var specialVariables = []string{
+	"%source-mime-ext",
+	"%destination-mime-ext",
+	"%destination-mime-ext:-",
+	"%source-mime-pandoc",
+	"%destination-mime-pandoc",
+	"%source-mime-declared",
+	"%source-mime-detected",
+	"%target",
+	"%source-uri",
+	"%file-upload-uri",
+	"%destination-uri",
+	"%canonical",
+}
+
+// expandVariable replaces the placeholders in a command argument with their values for message.
+// %% is replaced with a literal %.
+// An argument that's only a placeholder without a value, like %canonical with no canonical URL, is dropped.
+func expandVariable(a string, message api.Payload) ([]string, error) {
+	if slices.Contains(specialVariables, a) {
+		return variableValue(a, message)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(a); {
+		if a[i] != '%' {
+			b.WriteByte(a[i])
+			i++
+			continue
+		}
+		if strings.HasPrefix(a[i:], "%%") {
+			b.WriteByte('%')
+			i += 2
+			continue
+		}
+
+		name := variableAt(a[i:])
+		if name == "" {
+			// unknown placeholders are rejected when the config is read
+			b.WriteByte('%')
+			i++
+			continue
+		}
+		values, err := variableValue(name, message)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(strings.Join(values, ""))
+		i += len(name)
+	}
+
+	return []string{b.String()}, nil
+}
+
+// variableAt returns the placeholder s starts with, or "" if it doesn't start with one.
+// The longest placeholder that isn't followed by more of a name wins,
+// so %destination-mime-ext:- is preferred over %destination-mime-ext and %targets isn't %target.
+func variableAt(s string) string {
+	name := ""
+	for _, v := range specialVariables {
+		if len(v) > len(name) && strings.HasPrefix(s, v) && !startsName(s[len(v):]) {
+			name = v
+		}
+	}
+
+	return name
+}
+
+// startsName reports whether s starts like a placeholder name, i.e. with a lowercase letter.
+func startsName(s string) bool {
+	return s != "" && s[0] >= 'a' && s[0] <= 'z'
+}
+
+// validatePlaceholders checks that every placeholder in a command argument is known.
+// %args must be an argument of its own, since it's replaced with any number of arguments.
+func validatePlaceholders(a string) error {
+	if a == "%args" {
+		return nil
+	}
+
+	for i := 0; i < len(a); i++ {
+		if a[i] != '%' {
+			continue
+		}
+		if strings.HasPrefix(a[i:], "%%") {
+			i++
+			continue
+		}
+		if name := variableAt(a[i:]); name != "" {
+			i += len(name) - 1
+			continue
+		}
+		if strings.HasPrefix(a[i:], "%args") {
+			return fmt.Errorf("%%args must be an argument of its own: %q", a)
+		}
+		if startsName(a[i+1:]) {
+			return fmt.Errorf("unknown placeholder in %q, use %%%% for a literal %%", a)
+		}
+	}
+
+	return nil
+}
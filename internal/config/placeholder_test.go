@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandVariable(t *testing.T) {
+	message := api.Payload{Target: "thumbnail"}
+	message.Attachment.Content.SourceMimeType = "application/pdf"
+	message.Attachment.Content.DestinationMimeType = "image/webp"
+	message.Object.URL = []api.Link{{Rel: "canonical", Href: "https://example.com/node/1"}}
+	noCanonical := message
+	noCanonical.Object.URL = nil
+
+	tests := []struct {
+		name    string
+		arg     string
+		message api.Payload
+		want    []string
+	}{
+		{name: "literal", arg: "-quality", message: message, want: []string{"-quality"}},
+		{name: "whole argument", arg: "%target", message: message, want: []string{"thumbnail"}},
+		{name: "suffix", arg: "-o=/tmp/out.%destination-mime-ext", message: message, want: []string{"-o=/tmp/out.webp"}},
+		{name: "prefix", arg: "%source-mime-ext:-[0]", message: message, want: []string{"pdf:-[0]"}},
+		{name: "dash suffix", arg: "%destination-mime-ext:-", message: message, want: []string{"webp:-"}},
+		{name: "several", arg: "%target-%source-mime-ext.%destination-mime-ext", message: message, want: []string{"thumbnail-pdf.webp"}},
+		{name: "flag", arg: "--url=%canonical", message: message, want: []string{"--url=https://example.com/node/1"}},
+		{name: "flag without a value", arg: "--url=%canonical", message: noCanonical, want: []string{"--url="}},
+		{name: "whole argument without a value", arg: "%canonical", message: noCanonical, want: nil},
+		{name: "escaped", arg: "-resize 50%%", message: message, want: []string{"-resize 50%"}},
+		{name: "escaped placeholder", arg: "%%target", message: message, want: []string{"%target"}},
+		{name: "lone percent", arg: "50%", message: message, want: []string{"50%"}},
+		{name: "not a name", arg: "frame-%03d.png", message: message, want: []string{"frame-%03d.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandVariable(tt.arg, tt.message)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidatePlaceholders(t *testing.T) {
+	tests := []struct {
+		arg     string
+		wantErr bool
+	}{
+		{arg: "%args"},
+		{arg: "-o=/tmp/out.%destination-mime-ext"},
+		{arg: "%source-mime-ext:-[0]"},
+		{arg: "-resize 50%%"},
+		{arg: "frame-%03d.png"},
+		{arg: "50%"},
+		{arg: "%sourc-uri", wantErr: true},
+		{arg: "%targets", wantErr: true},
+		{arg: "-format %w", wantErr: true},
+		{arg: "--extra=%args", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			err := validatePlaceholders(tt.arg)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
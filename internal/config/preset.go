@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// presetPrefix selects a preset from X-Islandora-Args, e.g. preset:thumbnail.
+const presetPrefix = "preset:"
+
+// FreeformArgs reports whether X-Islandora-Args can pass arguments other than presets to the command.
+func (c Command) FreeformArgs() bool {
+	return c.AllowFreeformArgs == nil || *c.AllowFreeformArgs
+}
+
+// presetArgs returns the arguments of the command's preset called name.
+func (c Command) presetArgs(name string) ([]string, error) {
+	args, ok := c.Presets[name]
+	if !ok {
+		return nil, &ArgError{Arg: name, Reason: "is not a preset for this command"}
+	}
+
+	return args, nil
+}
+
+// validatePresets checks the command's presets for configuration errors.
+func (c Command) validatePresets() error {
+	if len(c.Presets) > 0 && !slices.Contains(c.Args, "%args") {
+		return errors.New("presets are passed with %args, which args doesn't have")
+	}
+	for name := range c.Presets {
+		if name == "" || strings.ContainsFunc(name, unicode.IsSpace) {
+			return fmt.Errorf("invalid preset name %q", name)
+		}
+	}
+
+	return nil
+}
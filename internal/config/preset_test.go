@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand_PassedArgs_Presets(t *testing.T) {
+	presets := map[string][]string{
+		"thumbnail":    {"-thumbnail", "100x100>", "-strip"},
+		"service-file": {"-resize", "1600x1600>"},
+	}
+	no := false
+
+	tests := []struct {
+		name      string
+		command   Command
+		args      string
+		preset    string
+		want      []string
+		wantError string
+	}{
+		{
+			name:    "preset in args",
+			command: Command{Presets: presets},
+			args:    "preset:thumbnail",
+			want:    []string{"-thumbnail", "100x100>", "-strip"},
+		},
+		{
+			name:    "preset header",
+			command: Command{Presets: presets},
+			preset:  "service-file",
+			want:    []string{"-resize", "1600x1600>"},
+		},
+		{
+			name:    "presets with free-form args",
+			command: Command{Presets: presets},
+			args:    "-quality 80 preset:thumbnail",
+			preset:  "service-file",
+			want:    []string{"-resize", "1600x1600>", "-quality", "80", "-thumbnail", "100x100>", "-strip"},
+		},
+		{
+			name:    "presets aren't checked by the argument policy",
+			command: Command{Presets: presets, ArgPolicy: &ArgPolicy{ForbiddenFlags: []string{"-strip"}}},
+			args:    "preset:thumbnail",
+			want:    []string{"-thumbnail", "100x100>", "-strip"},
+		},
+		{
+			name:      "free-form args are",
+			command:   Command{Presets: presets, ArgPolicy: &ArgPolicy{ForbiddenFlags: []string{"-strip"}}},
+			args:      "preset:thumbnail -strip",
+			wantError: `argument "-strip" is forbidden`,
+		},
+		{
+			name: "presets can't be a flag's value",
+			command: Command{Presets: presets, ArgPolicy: &ArgPolicy{
+				AllowedFlags: map[string]string{"-quality": `.+`},
+			}},
+			args:      "-quality preset:thumbnail /etc/passwd",
+			wantError: `argument "preset:thumbnail" can't be the value of -quality`,
+		},
+		{
+			name: "presets between allowed flags",
+			command: Command{Presets: presets, ArgPolicy: &ArgPolicy{
+				AllowedFlags: map[string]string{"-quality": `[0-9]+`},
+				MaxArgs:      2,
+			}},
+			args: "-quality 80 preset:thumbnail",
+			want: []string{"-quality", "80", "-thumbnail", "100x100>", "-strip"},
+		},
+		{
+			name:      "unknown preset",
+			command:   Command{Presets: presets},
+			args:      "preset:ocr-eng",
+			wantError: `argument "ocr-eng" is not a preset for this command`,
+		},
+		{
+			name:      "unknown preset header",
+			command:   Command{},
+			preset:    "thumbnail",
+			wantError: `argument "thumbnail" is not a preset for this command`,
+		},
+		{
+			name:    "only presets",
+			command: Command{Presets: presets, AllowFreeformArgs: &no},
+			args:    "preset:thumbnail",
+			want:    []string{"-thumbnail", "100x100>", "-strip"},
+		},
+		{
+			name:      "free-form args not allowed",
+			command:   Command{Presets: presets, AllowFreeformArgs: &no},
+			args:      "preset:thumbnail -quality 80",
+			wantError: `argument "-quality" is not allowed, only presets can be used`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.command.PassedArgs(tt.args, tt.preset)
+			if tt.wantError != "" {
+				var argErr *ArgError
+				require.True(t, errors.As(err, &argErr), "got %v", err)
+				assert.Equal(t, tt.wantError, argErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommand_ValidatePresets(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   Command
+		wantError bool
+	}{
+		{name: "no presets", command: Command{Cmd: "cat"}},
+		{name: "presets", command: Command{Args: []string{"-", "%args"}, Presets: map[string][]string{"thumbnail": {"-strip"}}}},
+		{name: "presets without %args", command: Command{Args: []string{"-"}, Presets: map[string][]string{"thumbnail": {"-strip"}}}, wantError: true},
+		{name: "empty name", command: Command{Args: []string{"%args"}, Presets: map[string][]string{"": {"-strip"}}}, wantError: true},
+		{name: "name with a space", command: Command{Args: []string{"%args"}, Presets: map[string][]string{"ocr eng": {"-l", "eng"}}}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.command.Validate()
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RateLimitConfig defines token bucket rate limits for each kind of client.
+// Each limit is tracked separately for every client IP, JWT subject or actor.
+//
+// swagger:model RateLimitConfig
+type RateLimitConfig struct {
+	// Limit for each client IP address.
+	//
+	// required: false
+	PerClientIP *RateLimit `yaml:"perClientIp,omitempty"`
+
+	// Limit for each JWT subject.
+	//
+	// required: false
+	PerSubject *RateLimit `yaml:"perSubject,omitempty"`
+
+	// Limit for each event actor, i.e. the Drupal user that triggered the event.
+	//
+	// required: false
+	PerActor *RateLimit `yaml:"perActor,omitempty"`
+}
+
+// RateLimit allows a number of requests per period, refilled continuously.
+//
+// swagger:model RateLimit
+type RateLimit struct {
+	// Number of requests allowed each period.
+	//
+	// required: true
+	Requests int `yaml:"requests"`
+
+	// Length of the period.
+	//
+	// required: false
+	// default: 1s
+	Per time.Duration `yaml:"per,omitempty"`
+
+	// Number of requests that can be made at once before being limited.
+	//
+	// required: false
+	// default: the value of requests
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// PerSecond returns the number of requests allowed each second.
+func (l *RateLimit) PerSecond() float64 {
+	per := l.Per
+	if per == 0 {
+		per = time.Second
+	}
+
+	return float64(l.Requests) / per.Seconds()
+}
+
+// BurstSize returns the number of requests that can be made at once.
+func (l *RateLimit) BurstSize() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return max(l.Requests, 1)
+}
+
+// Validate checks the rate limits for configuration errors.
+func (r *RateLimitConfig) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for name, l := range map[string]*RateLimit{
+		"perClientIp": r.PerClientIP,
+		"perSubject":  r.PerSubject,
+		"perActor":    r.PerActor,
+	} {
+		if l == nil {
+			continue
+		}
+		if l.Requests <= 0 || l.Per < 0 || l.Burst < 0 {
+			return fmt.Errorf("rate limit %s must allow a positive number of requests", name)
+		}
+	}
+
+	return nil
+}
+
+// errUnverifiedSubject is returned for per-subject limits when JWTs aren't verified,
+// since the client could then send any subject it likes.
+var errUnverifiedSubject = errors.New("rate limit perSubject requires jwksUri to verify JWT subjects")
+
+// validateSubjectLimits checks per-subject limits are only set when JWTs are verified.
+func (c *ServerConfig) validateSubjectLimits() error {
+	if c.JwksUri != "" {
+		return nil
+	}
+	if c.RateLimit != nil && c.RateLimit.PerSubject != nil {
+		return errUnverifiedSubject
+	}
+	for mimeType, cmd := range c.CmdByMimeType {
+		if cmd.RateLimit != nil && cmd.RateLimit.PerSubject != nil {
+			return fmt.Errorf("cmdByMimeType %s: %w", mimeType, errUnverifiedSubject)
+		}
+	}
+	for name, cmd := range c.Commands {
+		if cmd.RateLimit != nil && cmd.RateLimit.PerSubject != nil {
+			return fmt.Errorf("commands %s: %w", name, errUnverifiedSubject)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  *RateLimitConfig
+		wantErr bool
+	}{
+		{name: "unset"},
+		{
+			name:   "valid",
+			limits: &RateLimitConfig{PerClientIP: &RateLimit{Requests: 10, Per: time.Minute, Burst: 20}},
+		},
+		{
+			name:    "no requests",
+			limits:  &RateLimitConfig{PerActor: &RateLimit{Per: time.Minute}},
+			wantErr: true,
+		},
+		{
+			name:    "negative period",
+			limits:  &RateLimitConfig{PerSubject: &RateLimit{Requests: 1, Per: -time.Second}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRateLimit_Defaults(t *testing.T) {
+	l := &RateLimit{Requests: 5}
+	assert.Equal(t, 5.0, l.PerSecond())
+	assert.Equal(t, 5, l.BurstSize())
+
+	l = &RateLimit{Requests: 30, Per: time.Minute, Burst: 2}
+	assert.Equal(t, 0.5, l.PerSecond())
+	assert.Equal(t, 2, l.BurstSize())
+}
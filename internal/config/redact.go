@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// RedactConfig defines secrets to remove from logged command lines, URLs and command output.
+// Bearer tokens, JWTs and the Authorization header of the request are always redacted.
+//
+// swagger:model RedactConfig
+type RedactConfig struct {
+	// Query parameters whose values are redacted from URLs, in addition to common signing parameters like X-Amz-Signature.
+	//
+	// required: false
+	QueryParams []string `yaml:"queryParams,omitempty"`
+
+	// Regular expressions whose matches are redacted.
+	//
+	// required: false
+	Patterns []string `yaml:"patterns,omitempty"`
+
+	// Special argument variables whose values for each event are redacted, e.g. %source-uri.
+	//
+	// required: false
+	Placeholders []string `yaml:"placeholders,omitempty"`
+}
+
+// Validate checks the redaction rules for configuration errors.
+func (r *RedactConfig) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for _, p := range r.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+	}
+	for _, p := range r.Placeholders {
+		if !slices.Contains(specialVariables, p) {
+			return fmt.Errorf("unknown redact placeholder %q", p)
+		}
+	}
+
+	return nil
+}
+
+// SecretValues returns the values to redact from logs while handling message:
+// its Authorization header and the values of the placeholders configured to be redacted.
+func (c *ServerConfig) SecretValues(message api.Payload) []string {
+	values := []string{}
+	if message.Authorization != "" {
+		values = append(values, message.Authorization)
+		if _, token, ok := strings.Cut(message.Authorization, " "); ok {
+			values = append(values, strings.TrimSpace(token))
+		}
+	}
+	if c.Redact == nil {
+		return values
+	}
+
+	for _, p := range c.Redact.Placeholders {
+		// placeholders that can't be worked out yet, like MIME type extensions, aren't secrets
+		v, err := expandVariable(p, message)
+		if err == nil {
+			values = append(values, v...)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resumableBody streams a source's response body, resuming the download with a Range request
+// if the connection drops. The resumed response is only used if the source is unchanged,
+// which is checked with If-Range against the original response's ETag or Last-Modified.
+type resumableBody struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client *http.Client
+	req    *http.Request
+	body   io.ReadCloser
+
+	// validator is the If-Range value, a strong ETag or Last-Modified date
+	validator string
+	offset    int64
+
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// newResumableBody wraps the response body so it's resumed up to f.ResumeRetries times.
+// The body is returned unwrapped if resuming is disabled or the source can't be validated.
+// Resumes share f.Timeout with the original request, which was sent at started.
+func newResumableBody(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response, f *FetchConfig, started time.Time) io.ReadCloser {
+	if f == nil || f.ResumeRetries <= 0 || resp.Header.Get("Accept-Ranges") == "none" {
+		return resp.Body
+	}
+
+	// weak ETags can't be used with If-Range
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		slog.Debug("Source has no ETag or Last-Modified, it can't be resumed", "url", req.URL.String())
+		return resp.Body
+	}
+
+	backoff := f.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := f.MaxRetryBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	// the client's timeout only covers a single request, so each resume would get a fresh one
+	cancel := context.CancelFunc(func() {})
+	if f.Timeout > 0 {
+		ctx, cancel = context.WithDeadline(ctx, started.Add(f.Timeout))
+	}
+
+	return &resumableBody{
+		ctx:        ctx,
+		cancel:     cancel,
+		client:     client,
+		req:        req,
+		body:       resp.Body,
+		validator:  validator,
+		retries:    f.ResumeRetries,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+func (r *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if r.retries <= 0 || r.ctx.Err() != nil {
+			return n, err
+		}
+		// hand over what was read, the next read gets the error again
+		if n > 0 {
+			return n, nil
+		}
+
+		slog.Warn("Source download interrupted, resuming", "url", r.req.URL.String(), "offset", r.offset, "err", err, "backoff", r.backoff)
+		if resumeErr := r.resume(); resumeErr != nil {
+			return n, fmt.Errorf("%w: unable to resume download: %w", err, resumeErr)
+		}
+	}
+}
+
+// resume requests the rest of the source from the current offset.
+func (r *resumableBody) resume() error {
+	r.retries--
+	r.body.Close()
+
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-time.After(r.backoff):
+	}
+	r.backoff = min(r.backoff*2, r.maxBackoff)
+
+	req := r.req.Clone(r.ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	req.Header.Set("If-Range", r.validator)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		// keep a closed body so the next read fails and is retried
+		r.body = io.NopCloser(errReader{err})
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		// a 200 means the If-Range validator didn't match, so the source changed
+		return fmt.Errorf("source responded with status %d to range request", resp.StatusCode)
+	}
+	if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != r.offset {
+		resp.Body.Close()
+		return fmt.Errorf("source sent content range %q, wanted offset %d", resp.Header.Get("Content-Range"), r.offset)
+	}
+	r.body = resp.Body
+
+	return nil
+}
+
+func (r *resumableBody) Close() error {
+	defer r.cancel()
+	return r.body.Close()
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header like "bytes 100-199/200".
+func contentRangeStart(contentRange string) (int64, bool) {
+	rest, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+
+	return n, err == nil
+}
+
+// errReader returns err from every read.
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
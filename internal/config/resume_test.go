@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSource_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	modTime := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		etag         string
+		lastModified bool
+		changeETag   bool
+		drops        int32
+		retries      int
+		wantError    bool
+		wantRequests int32
+	}{
+		{
+			name:         "resumed with etag",
+			etag:         `"v1"`,
+			drops:        2,
+			retries:      3,
+			wantRequests: 3,
+		},
+		{
+			name:         "resumed with last-modified",
+			lastModified: true,
+			drops:        1,
+			retries:      1,
+			wantRequests: 2,
+		},
+		{
+			name:         "weak etag falls back to last-modified",
+			etag:         `W/"v1"`,
+			lastModified: true,
+			drops:        1,
+			retries:      1,
+			wantRequests: 2,
+		},
+		{
+			name:         "retry budget exhausted",
+			etag:         `"v1"`,
+			drops:        3,
+			retries:      2,
+			wantError:    true,
+			wantRequests: 3,
+		},
+		{
+			name:         "not resumed when disabled",
+			etag:         `"v1"`,
+			drops:        1,
+			wantError:    true,
+			wantRequests: 1,
+		},
+		{
+			name:         "not resumed without validator",
+			drops:        1,
+			retries:      3,
+			wantError:    true,
+			wantRequests: 1,
+		},
+		{
+			name:         "source changed",
+			etag:         `"v1"`,
+			changeETag:   true,
+			drops:        1,
+			retries:      3,
+			wantError:    true,
+			wantRequests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				etag := tt.etag
+				if tt.changeETag && n > 1 {
+					etag = `"v2"`
+				}
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+				w.Header().Set("Content-Type", "application/octet-stream")
+				// http.ServeContent only sends Last-Modified for a non-zero modtime
+				lastModified := time.Time{}
+				if tt.lastModified {
+					lastModified = modTime
+					w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+				}
+				if n > tt.drops {
+					http.ServeContent(w, r, "", lastModified, bytes.NewReader(data))
+					return
+				}
+
+				// send part of what was asked for then drop the connection
+				start := 0
+				if rng := r.Header.Get("Range"); rng != "" {
+					start, _ = strconv.Atoi(rng[len("bytes=") : len(rng)-1])
+					w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(data)-1)+"/"+strconv.Itoa(len(data)))
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+					w.WriteHeader(http.StatusPartialContent)
+				} else {
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				}
+				_, _ = w.Write(data[start : start+100000])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}))
+			defer source.Close()
+
+			c := &ServerConfig{
+				SourcePolicy: loopbackPolicy,
+				Fetch: &FetchConfig{
+					ResumeRetries: tt.retries,
+					RetryBackoff:  time.Millisecond,
+				},
+			}
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = source.URL
+
+			src, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, status)
+			defer src.Close()
+
+			b, err := io.ReadAll(src.Body)
+			assert.Equal(t, tt.wantRequests, requests.Load())
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, data, b)
+		})
+	}
+}
+
+func TestOpenSource_ResumeTimeout(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+
+	// every response is slow and drops after a tenth of the file
+	var requests atomic.Int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		start := 0
+		w.Header().Set("ETag", `"v1"`)
+		if rng := r.Header.Get("Range"); rng != "" {
+			start, _ = strconv.Atoi(rng[len("bytes=") : len(rng)-1])
+			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(data)-1)+"/"+strconv.Itoa(len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		_, _ = w.Write(data[start:min(start+100000, len(data))])
+		w.(http.Flusher).Flush()
+		if start+100000 < len(data) {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	defer source.Close()
+
+	// each request fits in the timeout but the whole download doesn't
+	c := &ServerConfig{
+		SourcePolicy: loopbackPolicy,
+		Fetch: &FetchConfig{
+			Timeout:       200 * time.Millisecond,
+			ResumeRetries: 20,
+			RetryBackoff:  time.Millisecond,
+		},
+	}
+	message := api.Payload{}
+	message.Attachment.Content.SourceURI = source.URL
+
+	src, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	defer src.Close()
+
+	_, err = io.ReadAll(src.Body)
+	assert.Error(t, err)
+	assert.Less(t, requests.Load(), int32(10))
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// Route selects the command to run for requests matching all of its conditions.
+// Conditions that aren't set match any request.
+//
+// swagger:model Route
+type Route struct {
+	// Name of the route, used when explaining which route a request matched.
+	//
+	// required: false
+	Name string `yaml:"name,omitempty"`
+
+	// Source MIME types, with wildcards like image/* and parameters like text/plain; charset=utf-8.
+	//
+	// required: false
+	SourceMimeTypes []string `yaml:"sourceMimeTypes,omitempty"`
+
+	// Destination MIME types, with wildcards like image/* and parameters like text/plain; charset=utf-8.
+	//
+	// required: false
+	DestinationMimeTypes []string `yaml:"destinationMimeTypes,omitempty"`
+
+	// Event types, e.g. Create or Update.
+	//
+	// required: false
+	Types []string `yaml:"types,omitempty"`
+
+	// Regex the event summary must match.
+	//
+	// required: false
+	Summary string `yaml:"summary,omitempty"`
+
+	// Regex the X-Islandora-Args arguments must match.
+	//
+	// required: false
+	Args string `yaml:"args,omitempty"`
+
+	// Hosts of the source URI, with wildcards like *.example.com.
+	//
+	// required: false
+	SourceHosts []string `yaml:"sourceHosts,omitempty"`
+
+	// Minimum size of the source in bytes.
+	// Sources of unknown size don't match.
+	//
+	// required: false
+	MinSourceBytes int64 `yaml:"minSourceBytes,omitempty"`
+
+	// Maximum size of the source in bytes.
+	// Sources of unknown size don't match.
+	//
+	// required: false
+	MaxSourceBytes int64 `yaml:"maxSourceBytes,omitempty"`
+
+	// Name of the command in commands to run.
+	//
+	// required: true
+	Command string `yaml:"command"`
+
+	// summaryRegexp and argsRegexp are Summary and Args compiled by validateRoutes.
+	summaryRegexp *regexp.Regexp
+	argsRegexp    *regexp.Regexp
+}
+
+// validateRoutes checks the routes and named commands for configuration errors.
+func (c *ServerConfig) validateRoutes() error {
+	for name := range c.Commands {
+		if _, exists := c.CmdByMimeType[name]; exists {
+			return fmt.Errorf("command %s is also a cmdByMimeType key", name)
+		}
+	}
+
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if _, exists := c.Commands[r.Command]; !exists {
+			return fmt.Errorf("%s: command %q isn't in commands", r.label(i), r.Command)
+		}
+		var err error
+		if r.summaryRegexp, err = regexp.Compile(r.Summary); err != nil {
+			return fmt.Errorf("%s: invalid summary regex: %w", r.label(i), err)
+		}
+		if r.argsRegexp, err = regexp.Compile(r.Args); err != nil {
+			return fmt.Errorf("%s: invalid args regex: %w", r.label(i), err)
+		}
+		if r.MinSourceBytes < 0 || r.MaxSourceBytes < 0 {
+			return fmt.Errorf("%s: minSourceBytes and maxSourceBytes can't be negative", r.label(i))
+		}
+		for _, host := range r.SourceHosts {
+			if _, err := path.Match(host, ""); err != nil {
+				return fmt.Errorf("%s: invalid source host %q", r.label(i), host)
+			}
+		}
+	}
+
+	return nil
+}
+
+// route returns the index of the first route matching the message, or -1 if none do.
+func (c *ServerConfig) route(message api.Payload) int {
+	for i, r := range c.Routes {
+		if r.mismatch(message) == "" {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ExplainCommand describes how the message's command is selected:
+// why each route did or didn't match, and the command that's run.
+func (c *ServerConfig) ExplainCommand(message api.Payload) string {
+	var b strings.Builder
+	for i, r := range c.Routes {
+		reason := r.mismatch(message)
+		if reason == "" {
+			fmt.Fprintf(&b, "%s: matched, running command %s\n", r.label(i), r.Command)
+			return b.String()
+		}
+		fmt.Fprintf(&b, "%s: %s\n", r.label(i), reason)
+	}
+
+	key, _ := c.CommandFor(message)
+	fmt.Fprintf(&b, "no route matched, using cmdByMimeType %s for %q\n", key, c.commandMimeType(message))
+
+	return b.String()
+}
+
+// label identifies the route i in explanations and errors.
+func (r Route) label(i int) string {
+	if r.Name != "" {
+		return fmt.Sprintf("routes[%d] %s", i, r.Name)
+	}
+
+	return fmt.Sprintf("routes[%d]", i)
+}
+
+// mismatch returns why the route doesn't match the message, or "" if it does.
+func (r Route) mismatch(message api.Payload) string {
+	content := message.Attachment.Content
+	if len(r.SourceMimeTypes) > 0 && !matchesAnyMimeType(content.SourceMimeType, r.SourceMimeTypes) {
+		return fmt.Sprintf("source MIME type %q isn't one of %v", content.SourceMimeType, r.SourceMimeTypes)
+	}
+	if len(r.DestinationMimeTypes) > 0 && !matchesAnyMimeType(content.DestinationMimeType, r.DestinationMimeTypes) {
+		return fmt.Sprintf("destination MIME type %q isn't one of %v", content.DestinationMimeType, r.DestinationMimeTypes)
+	}
+	if len(r.Types) > 0 && !slices.Contains(r.Types, message.Type) {
+		return fmt.Sprintf("event type %q isn't one of %v", message.Type, r.Types)
+	}
+	if r.Summary != "" {
+		if !matchRegexp(r.summaryRegexp, r.Summary, message.Summary) {
+			return fmt.Sprintf("summary %q doesn't match %s", message.Summary, r.Summary)
+		}
+	}
+	if r.Args != "" {
+		if !matchRegexp(r.argsRegexp, r.Args, content.Args) {
+			return fmt.Sprintf("args %q don't match %s", content.Args, r.Args)
+		}
+	}
+	if len(r.SourceHosts) > 0 {
+		host := ""
+		if u, err := url.Parse(content.SourceURI); err == nil {
+			host = u.Hostname()
+		}
+		if !slices.ContainsFunc(r.SourceHosts, func(pattern string) bool {
+			ok, _ := path.Match(pattern, host)
+			return ok
+		}) {
+			return fmt.Sprintf("source host %q isn't one of %v", host, r.SourceHosts)
+		}
+	}
+	if r.MinSourceBytes > 0 || r.MaxSourceBytes > 0 {
+		size := content.SourceSize
+		switch {
+		case size < 0:
+			return "source size is unknown"
+		case size < r.MinSourceBytes:
+			return fmt.Sprintf("source size %d is less than %d", size, r.MinSourceBytes)
+		case r.MaxSourceBytes > 0 && size > r.MaxSourceBytes:
+			return fmt.Sprintf("source size %d is more than %d", size, r.MaxSourceBytes)
+		}
+	}
+
+	return ""
+}
+
+// matchRegexp reports whether s matches re, the compiled pattern,
+// compiling pattern instead for routes that weren't validated.
+func matchRegexp(re *regexp.Regexp, pattern, s string) bool {
+	if re == nil {
+		ok, _ := regexp.MatchString(pattern, s)
+		return ok
+	}
+
+	return re.MatchString(s)
+}
+
+// matchesAnyMimeType reports whether mimeType matches any of the patterns.
+func matchesAnyMimeType(mimeType string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		return matchMimeType(pattern, mimeType)
+	})
+}
+
+// matchMimeType reports whether mimeType matches pattern.
+// The pattern can be *, a type wildcard like image/* or a MIME type,
+// and any parameters it has must be on mimeType with the same values.
+func matchMimeType(pattern, mimeType string) bool {
+	if mimeType == "" {
+		return false
+	}
+	base, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+
+	patternBase, patternParams, _ := strings.Cut(pattern, ";")
+	patternBase = strings.ToLower(strings.TrimSpace(patternBase))
+	switch {
+	case patternBase == "*" || patternBase == "*/*":
+	case strings.HasSuffix(patternBase, "/*"):
+		if !strings.HasPrefix(base, strings.TrimSuffix(patternBase, "*")) {
+			return false
+		}
+	case patternBase != base:
+		return false
+	}
+
+	if strings.TrimSpace(patternParams) == "" {
+		return true
+	}
+	_, want, err := mime.ParseMediaType("x/x;" + patternParams)
+	if err != nil {
+		return false
+	}
+	for k, v := range want {
+		if !strings.EqualFold(params[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchMimeType(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		mimeType string
+		want     bool
+	}{
+		{"image/png", "image/png", true},
+		{"image/png", "IMAGE/PNG", true},
+		{"image/png", "image/jpeg", false},
+		{"image/*", "image/tiff", true},
+		{"image/*", "application/pdf", false},
+		{"*", "application/pdf", true},
+		{"*/*", "application/pdf", true},
+		{"*", "", false},
+		{"text/plain", "text/plain; charset=utf-8", true},
+		{"text/plain; charset=utf-8", "text/plain; charset=UTF-8", true},
+		{"text/plain; charset=utf-8", "text/plain; charset=iso-8859-1", false},
+		{"text/plain; charset=utf-8", "text/plain", false},
+		{"text/*; charset=utf-8", "text/html; charset=utf-8", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.mimeType, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchMimeType(tt.pattern, tt.mimeType))
+		})
+	}
+}
+
+func TestCommandFor(t *testing.T) {
+	c := &ServerConfig{
+		Routes: []Route{
+			{
+				Name:                 "pdf-thumbnail",
+				SourceMimeTypes:      []string{"application/pdf"},
+				DestinationMimeTypes: []string{"image/*"},
+				Command:              "pdftoppm",
+			},
+			{
+				Name:            "large-tiff",
+				SourceMimeTypes: []string{"image/tiff"},
+				MinSourceBytes:  1000,
+				Command:         "vips",
+			},
+			{
+				Name:        "ocr",
+				Types:       []string{"Create", "Update"},
+				Summary:     "(?i)ocr",
+				SourceHosts: []string{"*.example.com"},
+				Args:        "^-l ",
+				Command:     "tesseract",
+			},
+		},
+		Commands: map[string]Command{
+			"pdftoppm":  {Cmd: "pdftoppm"},
+			"vips":      {Cmd: "vips"},
+			"tesseract": {Cmd: "tesseract"},
+		},
+		CmdByMimeType: map[string]Command{
+			"image/png":  {Cmd: "pngcrush"},
+			"text/plain": {Cmd: "cat"},
+			"image/*":    {Cmd: "convert"},
+			"default":    {Cmd: "echo"},
+		},
+	}
+	require.NoError(t, c.validateRoutes())
+	assert.NotNil(t, c.Routes[2].summaryRegexp)
+	assert.NotNil(t, c.Routes[2].argsRegexp)
+
+	message := func(source, destination string, size int64) api.Payload {
+		m := api.Payload{}
+		m.Attachment.Content.SourceMimeType = source
+		m.Attachment.Content.DestinationMimeType = destination
+		m.Attachment.Content.SourceSize = size
+		return m
+	}
+	ocr := message("image/png", "text/plain", 10)
+	ocr.Type = "Create"
+	ocr.Summary = "Generate OCR"
+	ocr.Attachment.Content.SourceURI = "https://islandora.example.com/_flysystem/fedora/page.png"
+	ocr.Attachment.Content.Args = "-l eng"
+	wrongHost := ocr
+	wrongHost.Attachment.Content.SourceURI = "https://example.org/page.png"
+
+	tests := []struct {
+		name    string
+		message api.Payload
+		wantKey string
+		wantCmd string
+	}{
+		{name: "source and destination", message: message("application/pdf", "image/webp", 10), wantKey: "pdftoppm", wantCmd: "pdftoppm"},
+		{name: "destination doesn't match", message: message("application/pdf", "text/plain", 10), wantKey: "default", wantCmd: "echo"},
+		{name: "large enough", message: message("image/tiff", "image/webp", 1000), wantKey: "vips", wantCmd: "vips"},
+		{name: "too small", message: message("image/tiff", "image/webp", 999), wantKey: "image/*", wantCmd: "convert"},
+		{name: "unknown size", message: message("image/tiff", "image/webp", -1), wantKey: "image/*", wantCmd: "convert"},
+		{name: "event type, summary, host and args", message: ocr, wantKey: "tesseract", wantCmd: "tesseract"},
+		{name: "host doesn't match", message: wrongHost, wantKey: "image/png", wantCmd: "pngcrush"},
+		{name: "exact key", message: message("image/png", "", 0), wantKey: "image/png", wantCmd: "pngcrush"},
+		{name: "key without parameters", message: message("text/plain; charset=utf-8", "", 0), wantKey: "text/plain", wantCmd: "cat"},
+		{name: "wildcard key", message: message("image/jpeg", "", 0), wantKey: "image/*", wantCmd: "convert"},
+		{name: "default", message: message("audio/mpeg", "", 0), wantKey: "default", wantCmd: "echo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, cmd := c.CommandFor(tt.message)
+			assert.Equal(t, tt.wantKey, key, c.ExplainCommand(tt.message))
+			assert.Equal(t, tt.wantCmd, cmd.Cmd)
+		})
+	}
+}
+
+func TestExplainCommand(t *testing.T) {
+	c := &ServerConfig{
+		Routes: []Route{
+			{Name: "pdf", SourceMimeTypes: []string{"application/pdf"}, Command: "pdftoppm"},
+			{SourceMimeTypes: []string{"image/*"}, MaxSourceBytes: 100, Command: "convert"},
+		},
+		Commands: map[string]Command{
+			"pdftoppm": {Cmd: "pdftoppm"},
+			"convert":  {Cmd: "convert"},
+		},
+		CmdByMimeType: map[string]Command{
+			"default": {Cmd: "cat"},
+		},
+	}
+
+	message := api.Payload{}
+	message.Attachment.Content.SourceMimeType = "image/png"
+	message.Attachment.Content.SourceSize = 50
+	assert.Equal(t, `routes[0] pdf: source MIME type "image/png" isn't one of [application/pdf]
+routes[1]: matched, running command convert
+`, c.ExplainCommand(message))
+
+	message.Attachment.Content.SourceSize = 500
+	assert.Equal(t, `routes[0] pdf: source MIME type "image/png" isn't one of [application/pdf]
+routes[1]: source size 500 is more than 100
+no route matched, using cmdByMimeType default for "image/png"
+`, c.ExplainCommand(message))
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+)
+
+// SandboxConfig restricts what a command can do, so an exploited command can't take over the container.
+// Each request's command is given its own temporary directory as its working directory and TMPDIR.
+// Sandboxing is only supported on Linux.
+//
+// swagger:model SandboxConfig
+type SandboxConfig struct {
+	// Maximum CPU time in seconds (RLIMIT_CPU).
+	//
+	// required: false
+	CPUSeconds uint64 `yaml:"cpuSeconds,omitempty"`
+
+	// Maximum size of the command's virtual memory in bytes (RLIMIT_AS).
+	//
+	// required: false
+	AddressSpaceBytes uint64 `yaml:"addressSpaceBytes,omitempty"`
+
+	// Maximum number of open files (RLIMIT_NOFILE).
+	//
+	// required: false
+	OpenFiles uint64 `yaml:"openFiles,omitempty"`
+
+	// Maximum number of processes the command's user can have (RLIMIT_NPROC).
+	//
+	// required: false
+	Processes uint64 `yaml:"processes,omitempty"`
+
+	// Maximum memory in bytes for the command and its children, enforced with a cgroup v2 memory.max.
+	//
+	// required: false
+	MemoryBytes int64 `yaml:"memoryBytes,omitempty"`
+
+	// Maximum number of CPUs the command and its children can use, enforced with a cgroup v2 cpu.max.
+	//
+	// required: false
+	CPUs float64 `yaml:"cpus,omitempty"`
+
+	// cgroup v2 directory to create each command's cgroup in.
+	// It needs the memory and cpu controllers enabled in its cgroup.subtree_control.
+	// When it's not available, commands run without the cgroup limits.
+	//
+	// required: false
+	// default: /sys/fs/cgroup/scyllaridae
+	CgroupParent string `yaml:"cgroupParent,omitempty"`
+
+	// User ID to run the command as.
+	//
+	// required: false
+	UID *int `yaml:"uid,omitempty"`
+
+	// Group ID to run the command as.
+	//
+	// required: false
+	GID *int `yaml:"gid,omitempty"`
+
+	// Mount everything except the command's temporary directory read-only.
+	// This needs CAP_SYS_ADMIN and Linux 5.12 or later.
+	//
+	// required: false
+	// default: false
+	ReadOnly bool `yaml:"readOnly,omitempty"`
+
+	// Directory to create each command's temporary directory in.
+	//
+	// required: false
+	// default: the system temporary directory
+	TempDir string `yaml:"tempDir,omitempty"`
+}
+
+// Validate checks the sandbox for configuration errors.
+func (s *SandboxConfig) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return errors.New("sandbox is only supported on Linux")
+	}
+	if s.MemoryBytes < 0 || s.CPUs < 0 {
+		return errors.New("sandbox memoryBytes and cpus can't be negative")
+	}
+	if (s.UID != nil && *s.UID < 0) || (s.GID != nil && *s.GID < 0) {
+		return errors.New("sandbox uid and gid can't be negative")
+	}
+	if s.CgroupParent != "" && !filepath.IsAbs(s.CgroupParent) {
+		return errors.New("sandbox cgroupParent must be an absolute path")
+	}
+	if s.TempDir != "" && !filepath.IsAbs(s.TempDir) {
+		return errors.New("sandbox tempDir must be an absolute path")
+	}
+
+	return nil
+}
+
+// Cgroup returns the directory to create command cgroups in.
+func (s *SandboxConfig) Cgroup() string {
+	if s.CgroupParent == "" {
+		return "/sys/fs/cgroup/scyllaridae"
+	}
+
+	return s.CgroupParent
+}
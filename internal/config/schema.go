@@ -0,0 +1,11 @@
+package config
+
+import _ "embed"
+
+//go:generate go run ./schemagen -o scyllaridae.schema.json
+
+// Schema is the JSON Schema for config files, generated from ServerConfig and its doc comments.
+// Editors can use it to complete and check config files.
+//
+//go:embed scyllaridae.schema.json
+var Schema []byte
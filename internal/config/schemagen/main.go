@@ -0,0 +1,280 @@
+// Command schemagen generates the JSON Schema for scyllaridae config files
+// from config.ServerConfig and the doc comments on its types.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/islandora/scyllaridae/internal/config"
+)
+
+// schema is a JSON Schema, with the keywords the config needs.
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	AllOf                []*schema          `json:"allOf,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Defs                 map[string]*schema `json:"$defs,omitempty"`
+}
+
+// docs are the doc comments of a package's struct types and their fields.
+type docs struct {
+	types  map[string]string
+	fields map[string]map[string]string
+}
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the config package, to read doc comments from")
+	out := flag.String("o", "", "file to write the schema to, instead of stdout")
+	flag.Parse()
+
+	b, err := generate(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(b)
+	} else {
+		err = os.WriteFile(*out, b, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// generate returns the JSON Schema for config files, with descriptions from the Go files in dir.
+func generate(dir string) ([]byte, error) {
+	d, err := readDocs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := map[string]*schema{}
+	root := structSchema(reflect.TypeFor[config.ServerConfig](), d, defs)
+	delete(defs, "ServerConfig")
+	root.Schema = "https://json-schema.org/draft/2020-12/schema"
+	root.Title = "scyllaridae configuration"
+	root.Properties["include"] = &schema{
+		Description: "Config files this file is merged over, relative to it.",
+		OneOf: []*schema{
+			{Type: "string"},
+			{Type: "array", Items: &schema{Type: "string"}},
+		},
+	}
+	root.Defs = defs
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// readDocs reads the doc comments of the struct types in the Go files in dir.
+func readDocs(dir string) (docs, error) {
+	d := docs{types: map[string]string{}, fields: map[string]map[string]string{}}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return d, err
+	}
+
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return d, err
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				d.types[ts.Name.Name] = gen.Doc.Text()
+				fields := map[string]string{}
+				for _, field := range st.Fields.List {
+					for _, name := range field.Names {
+						fields[name.Name] = field.Doc.Text()
+					}
+				}
+				d.fields[ts.Name.Name] = fields
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// structSchema returns the schema for the struct type t, adding the structs it references to defs.
+func structSchema(t reflect.Type, d docs, defs map[string]*schema) *schema {
+	s := &schema{
+		Type:                 "object",
+		Description:          description(d.types[t.Name()]),
+		Properties:           map[string]*schema{},
+		AdditionalProperties: false,
+	}
+	defs[t.Name()] = s
+	addFields(s, t, d, defs)
+
+	return s
+}
+
+// addFields adds the fields of the struct type t to the schema s, including those of inlined structs.
+func addFields(s *schema, t reflect.Type, d docs, defs map[string]*schema) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if opts == "inline" {
+			addFields(s, f.Type, d, defs)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		doc := d.fields[t.Name()][f.Name]
+		fs := typeSchema(f.Type, d, defs)
+		if fs.Ref != "" {
+			// keywords next to $ref are ignored by older validators, so wrap it
+			fs = &schema{AllOf: []*schema{fs}}
+		}
+		fs.Description = strings.TrimSpace(description(doc) + "\n" + fs.Description)
+		if def, ok := annotation(doc, "default"); ok {
+			if v, ok := defaultValue(f.Type, def); ok {
+				fs.Default = v
+			} else {
+				fs.Description += "\nDefault: " + def
+			}
+		}
+		if required, _ := annotation(doc, "required"); required == "true" {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// typeSchema returns the schema for values of type t.
+func typeSchema(t reflect.Type, d docs, defs map[string]*schema) *schema {
+	if t == reflect.TypeFor[time.Duration]() {
+		return &schema{Type: "string", Description: "Go duration, e.g. 30s or 1m30s"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), d, defs)
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: typeSchema(t.Elem(), d, defs)}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), d, defs)}
+	case reflect.Struct:
+		if _, exists := defs[t.Name()]; !exists {
+			structSchema(t, d, defs)
+		}
+		return &schema{Ref: "#/$defs/" + t.Name()}
+	}
+
+	return &schema{}
+}
+
+// description returns a doc comment without its swagger annotations.
+func description(doc string) string {
+	var lines []string
+	for line := range strings.Lines(doc) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "swagger:") || strings.HasPrefix(line, "required:") || strings.HasPrefix(line, "default:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// annotation returns the value of a swagger annotation like "required: true" in a doc comment.
+func annotation(doc, name string) (string, bool) {
+	for line := range strings.Lines(doc) {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), name+":"); ok {
+			return strings.TrimSpace(value), true
+		}
+	}
+
+	return "", false
+}
+
+// defaultValue parses a default annotation as a value of type t.
+// It returns false when the annotation describes the default rather than being a value, like "0 (unlimited)".
+func defaultValue(t reflect.Type, def string) (any, bool) {
+	if t == reflect.TypeFor[time.Duration]() {
+		_, err := time.ParseDuration(def)
+		return def, err == nil
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		v, err := strconv.ParseBool(def)
+		return v, err == nil
+	case reflect.String:
+		return def, !strings.ContainsAny(def, "<>() ")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseInt(def, 10, 64)
+		return v, err == nil
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(def, 64)
+		return v, err == nil
+	}
+
+	return nil, false
+}
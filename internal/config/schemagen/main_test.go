@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_UpToDate(t *testing.T) {
+	b, err := generate("..")
+	require.NoError(t, err)
+	assert.Equal(t, string(b), string(config.Schema), "the schema is out of date, run go generate ./internal/config")
+}
+
+func TestGenerate(t *testing.T) {
+	b, err := generate("..")
+	require.NoError(t, err)
+
+	var s schema
+	require.NoError(t, json.Unmarshal(b, &s))
+	assert.Equal(t, false, s.AdditionalProperties)
+	assert.Contains(t, s.Properties, "include")
+	assert.NotContains(t, s.Defs, "ServerConfig")
+
+	// field names come from the yaml tags, descriptions from the doc comments
+	cmdByMimeType := s.Properties["cmdByMimeType"]
+	assert.Equal(t, "object", cmdByMimeType.Type)
+	assert.Equal(t, "Commands and arguments ran by MIME type.", cmdByMimeType.Description)
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/Command"}, cmdByMimeType.AdditionalProperties)
+
+	command := s.Defs["Command"]
+	require.NotNil(t, command)
+	assert.Equal(t, []string{"cmd"}, command.Required)
+	assert.Equal(t, "boolean", command.Properties["allowInsecureArgs"].Type)
+	assert.Equal(t, false, command.Properties["allowInsecureArgs"].Default)
+	assert.Equal(t, "#/$defs/ArgPolicy", command.Properties["argPolicy"].AllOf[0].Ref)
+	assert.Contains(t, command.Properties["maxInputBytes"].Description, "Default: 0 (unlimited)")
+
+	// durations are strings, with their default
+	connectTimeout := s.Defs["FetchConfig"].Properties["connectTimeout"]
+	assert.Equal(t, "string", connectTimeout.Type)
+	assert.Equal(t, "30s", connectTimeout.Default)
+
+	// services have the inlined top-level options
+	service := s.Defs["ServiceConfig"]
+	require.NotNil(t, service)
+	assert.Contains(t, service.Properties, "path")
+	assert.Contains(t, service.Properties, "cmdByMimeType")
+	assert.NotContains(t, service.Properties, "include")
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// reservedPaths are served by the server itself, so services can't be mounted at them.
+var reservedPaths = []string{"/healthcheck", "/metrics", "/cache"}
+
+// ServiceConfig is a service served at its own path.
+// It has all the options of the top-level config, other than the ones the whole server shares:
+// tls, redact and cache, which it inherits, and services.
+//
+// swagger:model ServiceConfig
+type ServiceConfig struct {
+	// Path the service is served at.
+	//
+	// required: false
+	// default: /<name>
+	Path string `yaml:"path,omitempty"`
+
+	ServerConfig `yaml:",inline"`
+}
+
+// Serves reports whether the top-level config has commands of its own to serve at /,
+// rather than only having services.
+func (c *ServerConfig) Serves() bool {
+	return len(c.Services) == 0 || len(c.CmdByMimeType) > 0 || len(c.Routes) > 0
+}
+
+// prepareServices sets the services' paths and defaults, and checks them for configuration errors.
+func (c *ServerConfig) prepareServices() error {
+	paths := map[string]string{}
+	for name, svc := range c.Services {
+		if name == "" || strings.ContainsAny(name, "/ ") {
+			return fmt.Errorf("invalid service name %q", name)
+		}
+		if svc == nil {
+			return fmt.Errorf("services %s: has no configuration", name)
+		}
+		if svc.Path == "" {
+			svc.Path = "/" + name
+		}
+		if !strings.HasPrefix(svc.Path, "/") {
+			return fmt.Errorf("services %s: path must start with /", name)
+		}
+		svc.Path = path.Clean(svc.Path)
+		if svc.Path == "/" || slices.ContainsFunc(reservedPaths, func(p string) bool {
+			return svc.Path == p || strings.HasPrefix(svc.Path, p+"/")
+		}) {
+			return fmt.Errorf("services %s: path %s is reserved", name, svc.Path)
+		}
+		if other, exists := paths[svc.Path]; exists {
+			return fmt.Errorf("services %s: path %s is also used by %s", name, svc.Path, other)
+		}
+		paths[svc.Path] = name
+
+		if svc.TLS != nil || svc.Redact != nil || svc.Cache != nil || svc.Services != nil {
+			return fmt.Errorf("services %s: tls, redact, cache and services can only be set for the whole server", name)
+		}
+		svc.TLS = c.TLS
+		svc.Redact = c.Redact
+		if err := svc.prepare(); err != nil {
+			return fmt.Errorf("services %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
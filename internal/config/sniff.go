@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// sniffLen is how many bytes of the source are inspected to detect its MIME type.
+const sniffLen = 512
+
+// magicNumbers maps file signatures at the start of a file to their MIME type.
+// These are checked before falling back to http.DetectContentType,
+// which doesn't know about most of the formats Islandora deals with.
+var magicNumbers = []struct {
+	offset   int
+	magic    []byte
+	mimeType string
+}{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), "image/jp2"},
+	{0, []byte("\xff\x4f\xff\x51"), "image/jp2"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("OggS"), "audio/ogg"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{4, []byte("ftypqt  "), "video/quicktime"},
+	{4, []byte("ftypM4A "), "audio/x-m4a"},
+	{4, []byte("ftypM4V"), "video/x-m4v"},
+	{4, []byte("ftyp3gp"), "video/3gpp"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{4, []byte("ftyp"), "video/mp4"},
+}
+
+// riffForms maps the form type of a RIFF file, after its "RIFF" and size, to its MIME type.
+var riffForms = map[string]string{
+	"WAVE": "audio/x-wav",
+	"AVI ": "video/x-msvideo",
+	"WEBP": "image/webp",
+}
+
+// SniffMimeType detects the MIME type of a file from its first bytes.
+// It returns an empty string if the type couldn't be determined from a file signature.
+func SniffMimeType(b []byte) string {
+	for _, m := range magicNumbers {
+		if len(b) >= m.offset+len(m.magic) && bytes.Equal(b[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.mimeType
+		}
+	}
+	if len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) {
+		if mimeType, ok := riffForms[string(b[8:12])]; ok {
+			return mimeType
+		}
+	}
+
+	// text detection is a guess based on the bytes being printable
+	// so only trust http.DetectContentType for signature based matches
+	detected := http.DetectContentType(b)
+	if detected == "application/octet-stream" || baseMimeType(detected) == "text/plain" || baseMimeType(detected) == "text/html" || baseMimeType(detected) == "text/xml" {
+		return ""
+	}
+
+	return baseMimeType(detected)
+}
+
+func baseMimeType(mimeType string) string {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+
+	return base
+}
+
+// sniffedBody reads the peeked bytes before the rest of the original body.
+type sniffedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Sniff peeks at the start of the source body to detect its MIME type
+// without consuming any bytes the command needs to read.
+func (s *Source) Sniff() error {
+	if s == nil || s.Body == nil {
+		return nil
+	}
+
+	br := bufio.NewReaderSize(s.Body, sniffLen)
+	b, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	s.DetectedMimeType = SniffMimeType(b)
+	s.Body = sniffedBody{Reader: br, Closer: s.Body}
+
+	return nil
+}
+
+// SetSourceMimeType sets the message's source MIME type from the opened source.
+// When sniffMimeType is enabled the type detected from the source's first bytes
+// takes precedence over the declared Content-Type, and both are kept for use as placeholders.
+func (c *ServerConfig) SetSourceMimeType(message *api.Payload, src *Source) error {
+	content := &message.Attachment.Content
+	if src.MimeType != "" {
+		content.SourceMimeType = src.MimeType
+	}
+	content.DeclaredSourceMimeType = content.SourceMimeType
+
+	if !c.SniffMimeType {
+		return nil
+	}
+
+	if err := src.Sniff(); err != nil {
+		return err
+	}
+	content.DetectedSourceMimeType = src.DetectedMimeType
+	if src.DetectedMimeType == "" {
+		slog.Debug("Unable to detect source MIME type, using declared type", "msgId", message.Object.ID, "declared", content.DeclaredSourceMimeType)
+		return nil
+	}
+
+	if baseMimeType(content.DeclaredSourceMimeType) != src.DetectedMimeType {
+		slog.Warn("Declared source MIME type does not match its contents",
+			"msgId", message.Object.ID,
+			"declared", content.DeclaredSourceMimeType,
+			"detected", src.DetectedMimeType,
+		)
+	}
+	content.SourceMimeType = src.DetectedMimeType
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffMimeType(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "pdf", data: "%PDF-1.7\n", want: "application/pdf"},
+		{name: "tiff little endian", data: "II*\x00\x08\x00\x00\x00", want: "image/tiff"},
+		{name: "tiff big endian", data: "MM\x00*\x00\x00\x00\x08", want: "image/tiff"},
+		{name: "jp2", data: "\x00\x00\x00\x0cjP  \r\n\x87\n\x00\x00\x00\x14ftypjp2 ", want: "image/jp2"},
+		{name: "jpeg", data: "\xff\xd8\xff\xe0\x00\x10JFIF", want: "image/jpeg"},
+		{name: "png", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", want: "image/png"},
+		{name: "wav", data: "RIFF\x24\x00\x00\x00WAVEfmt ", want: "audio/x-wav"},
+		{name: "webp", data: "RIFF\x24\x00\x00\x00WEBPVP8 ", want: "image/webp"},
+		{name: "WAVE without RIFF", data: "\x00\x00\x00\x00\x00\x00\x00\x00WAVEfmt ", want: ""},
+		{name: "mp4", data: "\x00\x00\x00\x18ftypisom\x00\x00\x02\x00", want: "video/mp4"},
+		{name: "quicktime", data: "\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", want: "video/quicktime"},
+		{name: "mp3", data: "ID3\x04\x00\x00\x00\x00\x00\x00", want: "audio/mpeg"},
+		{name: "plain text is not trusted", data: "hello world", want: ""},
+		{name: "html is not trusted", data: "<html><body></body></html>", want: ""},
+		{name: "empty", data: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SniffMimeType([]byte(tt.data)))
+		})
+	}
+}
+
+func TestSetSourceMimeType(t *testing.T) {
+	pdf := "%PDF-1.7\n" + strings.Repeat("x", 1024)
+
+	tests := []struct {
+		name         string
+		sniff        bool
+		declared     string
+		body         string
+		wantMimeType string
+		wantDetected string
+	}{
+		{
+			name:         "sniffing disabled trusts declared type",
+			declared:     "image/jpeg",
+			body:         pdf,
+			wantMimeType: "image/jpeg",
+		},
+		{
+			name:         "detected type wins",
+			sniff:        true,
+			declared:     "image/jpeg",
+			body:         pdf,
+			wantMimeType: "application/pdf",
+			wantDetected: "application/pdf",
+		},
+		{
+			name:         "undetectable keeps declared type",
+			sniff:        true,
+			declared:     "text/csv",
+			body:         "a,b,c\n1,2,3\n",
+			wantMimeType: "text/csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ServerConfig{SniffMimeType: tt.sniff}
+			src := &Source{
+				Body:     io.NopCloser(strings.NewReader(tt.body)),
+				MimeType: tt.declared,
+			}
+			message := api.Payload{}
+
+			require.NoError(t, c.SetSourceMimeType(&message, src))
+			assert.Equal(t, tt.wantMimeType, message.Attachment.Content.SourceMimeType)
+			assert.Equal(t, tt.declared, message.Attachment.Content.DeclaredSourceMimeType)
+			assert.Equal(t, tt.wantDetected, message.Attachment.Content.DetectedSourceMimeType)
+
+			// the bytes peeked at must still be passed to the command
+			b, err := io.ReadAll(src.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(b))
+		})
+	}
+}
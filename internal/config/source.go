@@ -0,0 +1,256 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// Source is an opened source file streamed to the command's stdin.
+type Source struct {
+	// Body streams the source contents. It is nil when there is no source to stream.
+	Body io.ReadCloser
+
+	// MimeType is the MIME type reported for the source, if any.
+	MimeType string
+
+	// DetectedMimeType is the MIME type detected from the source's first bytes, if sniffed.
+	DetectedMimeType string
+
+	// Size is the length of the source in bytes, or -1 if unknown.
+	Size int64
+
+	// Version identifies the source's contents, e.g. by its digest or its URI and ETag.
+	// It is empty if the source doesn't report one.
+	Version string
+}
+
+// Close closes the source body, if any.
+func (s *Source) Close() error {
+	if s == nil || s.Body == nil {
+		return nil
+	}
+
+	return s.Body.Close()
+}
+
+// ErrSourceUnavailable is returned when a source exists in a supported location but can't be read,
+// e.g. the source returned an error status or the file doesn't exist.
+var ErrSourceUnavailable = errors.New("source unavailable")
+
+// ErrInvalidSource is returned when a source URI is malformed.
+var ErrInvalidSource = errors.New("invalid source")
+
+// SourceResolver opens source URIs with a given scheme.
+type SourceResolver interface {
+	// Open opens the source, reporting its MIME type if known.
+	// auth is the Authorization header to forward to the source, if any.
+	Open(ctx context.Context, uri *url.URL, auth string) (*Source, error)
+}
+
+// SourcesConfig configures how source URIs that aren't fetched over HTTP are opened.
+// Each scheme must also be listed in sourcePolicy.allowedSchemes to be used.
+//
+// swagger:model SourcesConfig
+type SourcesConfig struct {
+	// Local files for file:// source URIs.
+	//
+	// required: false
+	File *FileSourceConfig `yaml:"file,omitempty"`
+
+	// S3 compatible storage for s3:// source URIs.
+	//
+	// required: false
+	S3 *S3SourceConfig `yaml:"s3,omitempty"`
+}
+
+// Validate checks the sources config for configuration errors.
+func (s *SourcesConfig) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.File.Validate(); err != nil {
+		return err
+	}
+
+	return s.S3.Validate()
+}
+
+// sourceResolver returns the resolver for a source URI scheme.
+func (c *ServerConfig) sourceResolver(scheme string) (SourceResolver, bool) {
+	c.resolversOnce.Do(func() {
+		sources := c.Sources
+		if sources == nil {
+			sources = &SourcesConfig{}
+		}
+		http := &httpResolver{config: c}
+		c.resolvers = map[string]SourceResolver{
+			"http":  http,
+			"https": http,
+			"file":  &fileResolver{config: sources.File},
+			"s3":    newS3Resolver(sources.S3, c.Fetch),
+			"data":  &dataResolver{},
+		}
+	})
+
+	r, ok := c.resolvers[scheme]
+	return r, ok
+}
+
+// OpenSource opens the source file for the request.
+// POST requests stream the request body. Otherwise the source URI is opened
+// by the resolver for its scheme, which reports the source's MIME type before its body is streamed to the command.
+// The returned status code is the HTTP status to respond with when an error is returned.
+func (c *ServerConfig) OpenSource(r *http.Request, message api.Payload, auth string) (*Source, int, error) {
+	if r.Method == http.MethodPost {
+		slog.Debug("Streaming body directly to command", "msgId", message.Object.ID)
+		return &Source{
+			Body:     r.Body,
+			MimeType: r.Header.Get("Content-Type"),
+			Size:     r.ContentLength,
+		}, http.StatusOK, nil
+	}
+	if message.Attachment.Content.SourceURI == "" {
+		slog.Debug("No source URI to stream", "msgId", message.Object.ID)
+		return &Source{Size: -1}, http.StatusOK, nil
+	}
+
+	slog.Debug("Opening SourceURI for streaming", "msgId", message.Object.ID, "SourceURI", message.Attachment.Content.SourceURI)
+	src, err := c.openSourceURI(r.Context(), message.Attachment.Content.SourceURI, auth)
+	if err != nil {
+		slog.Error("Error opening source", "uri", message.Attachment.Content.SourceURI, "err", err)
+		switch {
+		case errors.Is(err, ErrSourceNotAllowed):
+			return nil, http.StatusForbidden, fmt.Errorf("forbidden")
+		case errors.Is(err, ErrInvalidSource):
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request")
+		case errors.Is(err, ErrSourceUnavailable):
+			return nil, http.StatusFailedDependency, fmt.Errorf("failed dependency")
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("internal error")
+	}
+
+	return src, http.StatusOK, nil
+}
+
+func (c *ServerConfig) openSourceURI(ctx context.Context, uri, auth string) (*Source, error) {
+	// Drupal's stream wrapper URIs aren't URL encoded, so they may not parse as URLs
+	if w, rel, ok := c.streamWrapperTarget(uri); ok {
+		scheme, _, _ := strings.Cut(uri, "://")
+		if err := c.SourcePolicy.CheckURL(&url.URL{Scheme: strings.ToLower(scheme)}); err != nil {
+			return nil, err
+		}
+		if rel == "" {
+			return nil, fmt.Errorf("%w: %s has no path", ErrInvalidSource, uri)
+		}
+		return openInRoot(w.Root, filepath.FromSlash(rel))
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if err := c.SourcePolicy.CheckURL(u); err != nil {
+		return nil, err
+	}
+
+	resolver, ok := c.sourceResolver(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrSourceNotAllowed, u.Scheme)
+	}
+
+	return resolver.Open(ctx, u, auth)
+}
+
+// httpResolver fetches http:// and https:// source URIs with a single GET
+// whose response headers report the source's MIME type.
+type httpResolver struct {
+	config *ServerConfig
+}
+
+func (h *httpResolver) Open(ctx context.Context, u *url.URL, auth string) (*Source, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	if h.config.ForwardAuth == nil || *h.config.ForwardAuth {
+		req.Header.Set("Authorization", auth)
+	}
+	started := time.Now()
+	sourceResp, err := h.config.SourceClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if sourceResp.StatusCode != http.StatusOK {
+		sourceResp.Body.Close()
+		return nil, fmt.Errorf("%w: status %d", ErrSourceUnavailable, sourceResp.StatusCode)
+	}
+
+	slog.Debug("HTTP request created for source", "url", req.URL.String())
+
+	src := &Source{
+		Body:     newResumableBody(ctx, h.config.SourceClient(), req, sourceResp, h.config.Fetch, started),
+		MimeType: sourceResp.Header.Get("Content-Type"),
+		Size:     sourceResp.ContentLength,
+		Version:  httpVersion(u, sourceResp.Header),
+	}
+
+	// some servers only report the type on HEAD, e.g. when streaming a chunked response
+	if src.MimeType == "" {
+		slog.Debug("Source GET response has no Content-Type, falling back to HEAD", "url", req.URL.String())
+		p := api.Payload{}
+		p.Attachment.Content.SourceURI = u.String()
+		if err := p.FetchSourceMimeType(h.config.SourceClient(), req.Header.Get("Authorization")); err != nil {
+			src.Close()
+			return nil, fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
+		}
+		src.MimeType = p.Attachment.Content.SourceMimeType
+	}
+
+	return src, nil
+}
+
+// httpVersion identifies an HTTP source's contents by the digest it reports,
+// e.g. Fedora's Digest header, or failing that by its URI and strong ETag.
+func httpVersion(u *url.URL, h http.Header) string {
+	if digest := h.Get("Content-Digest"); digest != "" {
+		return "content-digest " + digest
+	}
+	if digest := h.Get("Digest"); digest != "" {
+		return "digest " + digest
+	}
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return u.String() + " " + etag
+	}
+
+	return ""
+}
+
+// guessMimeType sets the source's MIME type from its file extension,
+// or failing that from its first bytes.
+func (s *Source) guessMimeType(name string) error {
+	if ext := path.Ext(name); ext != "" {
+		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+			s.MimeType = mimeType
+			return nil
+		}
+	}
+	if err := s.Sniff(); err != nil {
+		return err
+	}
+	s.MimeType = s.DetectedMimeType
+
+	return nil
+}
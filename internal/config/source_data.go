@@ -0,0 +1,57 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// dataResolver opens inline data: source URIs as described in RFC 2397.
+type dataResolver struct{}
+
+func (d *dataResolver) Open(ctx context.Context, u *url.URL, auth string) (*Source, error) {
+	mediaType, data, ok := strings.Cut(u.Opaque, ",")
+	if !ok {
+		return nil, fmt.Errorf("%w: data URI has no data", ErrInvalidSource)
+	}
+
+	isBase64 := false
+	if m, ok := strings.CutSuffix(mediaType, ";base64"); ok {
+		mediaType = m
+		isBase64 = true
+	}
+	if mediaType == "" {
+		mediaType = "text/plain;charset=US-ASCII"
+	} else if strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+	}
+	if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+		return nil, fmt.Errorf("%w: data URI media type: %w", ErrInvalidSource, err)
+	}
+
+	unescaped, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	b := []byte(unescaped)
+	if isBase64 {
+		// padding is commonly left off
+		b, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(unescaped, "="))
+		if err != nil {
+			return nil, fmt.Errorf("%w: data URI base64: %w", ErrInvalidSource, err)
+		}
+	}
+
+	return &Source{
+		Body:     io.NopCloser(bytes.NewReader(b)),
+		MimeType: mediaType,
+		Size:     int64(len(b)),
+		Version:  fmt.Sprintf("sha256 %x", sha256.Sum256(b)),
+	}, nil
+}
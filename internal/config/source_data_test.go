@@ -0,0 +1,94 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSource_Data(t *testing.T) {
+	tests := []struct {
+		name       string
+		uri        string
+		wantStatus int
+		wantMime   string
+		wantBody   string
+	}{
+		{
+			name:       "base64",
+			uri:        "data:text/csv;base64,YSxiCjEsMgo=",
+			wantStatus: http.StatusOK,
+			wantMime:   "text/csv",
+			wantBody:   "a,b\n1,2\n",
+		},
+		{
+			name:       "base64 without padding",
+			uri:        "data:text/plain;base64,Zm9v",
+			wantStatus: http.StatusOK,
+			wantMime:   "text/plain",
+			wantBody:   "foo",
+		},
+		{
+			name:       "percent encoded",
+			uri:        "data:text/html;charset=utf-8,%3Cp%3Ehello%20world%3C%2Fp%3E",
+			wantStatus: http.StatusOK,
+			wantMime:   "text/html;charset=utf-8",
+			wantBody:   "<p>hello world</p>",
+		},
+		{
+			name:       "default media type",
+			uri:        "data:,hello",
+			wantStatus: http.StatusOK,
+			wantMime:   "text/plain;charset=US-ASCII",
+			wantBody:   "hello",
+		},
+		{
+			name:       "invalid base64",
+			uri:        "data:text/plain;base64,!!!",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing data",
+			uri:        "data:text/plain",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ServerConfig{SourcePolicy: &SourcePolicy{AllowedSchemes: []string{"data"}}}
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = tt.uri
+
+			src, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer src.Close()
+
+			assert.Equal(t, tt.wantMime, src.MimeType)
+			body, err := io.ReadAll(src.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+			assert.Equal(t, int64(len(tt.wantBody)), src.Size)
+		})
+	}
+}
+
+func TestOpenSource_DataNotAllowedByDefault(t *testing.T) {
+	c := &ServerConfig{}
+	message := api.Payload{}
+	message.Attachment.Content.SourceURI = "data:,hello"
+
+	_, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+}
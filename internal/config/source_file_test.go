@@ -0,0 +1,121 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSource_File(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "foo.pdf"), []byte("%PDF-1.7"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "noext"), []byte("\x89PNG\r\n\x1a\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0700))
+
+	tests := []struct {
+		name       string
+		schemes    []string
+		uri        string
+		wantStatus int
+		wantMime   string
+		wantBody   string
+	}{
+		{
+			name:       "file in root",
+			uri:        "file://" + filepath.Join(root, "foo.pdf"),
+			wantStatus: http.StatusOK,
+			wantMime:   "application/pdf",
+			wantBody:   "%PDF-1.7",
+		},
+		{
+			name:       "type sniffed without an extension",
+			uri:        "file://" + filepath.Join(root, "noext"),
+			wantStatus: http.StatusOK,
+			wantMime:   "image/png",
+			wantBody:   "\x89PNG\r\n\x1a\n",
+		},
+		{
+			name:       "file scheme not allowed",
+			schemes:    []string{"https"},
+			uri:        "file://" + filepath.Join(root, "foo.pdf"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "file outside root",
+			uri:        "file://" + filepath.Join(outside, "secret.txt"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "traversal out of root",
+			uri:        "file://" + root + "/../" + filepath.Base(outside) + "/secret.txt",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "symlink out of root",
+			uri:        "file://" + filepath.Join(root, "escape.txt"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "remote host",
+			uri:        "file://fileserver" + filepath.Join(root, "foo.pdf"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "missing file",
+			uri:        "file://" + filepath.Join(root, "missing.pdf"),
+			wantStatus: http.StatusFailedDependency,
+		},
+		{
+			name:       "directory",
+			uri:        "file://" + filepath.Join(root, "dir"),
+			wantStatus: http.StatusFailedDependency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemes := tt.schemes
+			if schemes == nil {
+				schemes = []string{"file"}
+			}
+			c := &ServerConfig{
+				SourcePolicy: &SourcePolicy{AllowedSchemes: schemes},
+				Sources: &SourcesConfig{
+					File: &FileSourceConfig{Roots: []string{root}},
+				},
+			}
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = tt.uri
+
+			src, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer src.Close()
+
+			assert.Equal(t, tt.wantMime, src.MimeType)
+			body, err := io.ReadAll(src.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func TestFileSourceConfig_Validate(t *testing.T) {
+	assert.NoError(t, (*FileSourceConfig)(nil).Validate())
+	assert.NoError(t, (&FileSourceConfig{Roots: []string{"/mnt/nfs"}}).Validate())
+	assert.Error(t, (&FileSourceConfig{Roots: []string{"mnt/nfs"}}).Validate())
+}
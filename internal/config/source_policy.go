@@ -0,0 +1,213 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrSourceNotAllowed is returned when a source URI is rejected by the source policy.
+var ErrSourceNotAllowed = errors.New("source not allowed")
+
+// SourcePolicy restricts which source URIs scyllaridae will fetch.
+//
+// swagger:model SourcePolicy
+type SourcePolicy struct {
+	// URI schemes allowed for source URIs.
+	// Supported schemes are http, https, file, s3 and data.
+	//
+	// required: false
+	// default: ["http", "https"]
+	AllowedSchemes []string `yaml:"allowedSchemes,omitempty"`
+
+	// Hostnames allowed for http(s) source URIs. A leading "*." matches any subdomain.
+	// If both allowedHosts and allowedCidrs are empty, any host is allowed.
+	//
+	// required: false
+	AllowedHosts []string `yaml:"allowedHosts,omitempty"`
+
+	// IP ranges allowed for source URIs, checked against the resolved address.
+	// Loopback and link-local addresses are denied unless they are listed here.
+	//
+	// required: false
+	AllowedCIDRs []string `yaml:"allowedCidrs,omitempty"`
+}
+
+func (p *SourcePolicy) schemes() []string {
+	if p == nil || len(p.AllowedSchemes) == 0 {
+		return []string{"http", "https"}
+	}
+	return p.AllowedSchemes
+}
+
+func (p *SourcePolicy) hasAllowlist() bool {
+	return p != nil && (len(p.AllowedHosts) > 0 || len(p.AllowedCIDRs) > 0)
+}
+
+func (p *SourcePolicy) hostAllowed(host string) bool {
+	if p == nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range p.AllowedHosts {
+		h = strings.ToLower(h)
+		if h == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(h, "*"); ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SourcePolicy) prefixes() ([]netip.Prefix, error) {
+	if p == nil {
+		return nil, nil
+	}
+	prefixes := make([]netip.Prefix, 0, len(p.AllowedCIDRs))
+	for _, c := range p.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowedCidrs entry %s: %w", c, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Validate checks the source policy for configuration errors.
+func (p *SourcePolicy) Validate() error {
+	_, err := p.prefixes()
+	return err
+}
+
+// CheckURL verifies a source URI's scheme and host are allowed by the policy.
+// Hosts only allowed by CIDR are checked again once their address is resolved.
+func (p *SourcePolicy) CheckURL(u *url.URL) error {
+	if !slices.Contains(p.schemes(), u.Scheme) {
+		return fmt.Errorf("%w: scheme %q", ErrSourceNotAllowed, u.Scheme)
+	}
+	// other schemes are constrained by their resolver's configuration
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	if !p.hasAllowlist() || p.hostAllowed(u.Hostname()) {
+		return nil
+	}
+
+	// hostnames may still resolve to an allowed CIDR
+	if len(p.AllowedCIDRs) > 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: host %q", ErrSourceNotAllowed, u.Hostname())
+}
+
+// CheckAddr verifies a resolved address may be connected to for the given host.
+func (p *SourcePolicy) CheckAddr(host string, addr netip.Addr) error {
+	prefixes, err := p.prefixes()
+	if err != nil {
+		return err
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w: %s resolves to denied address %s", ErrSourceNotAllowed, host, addr)
+	}
+
+	if p.hasAllowlist() && !p.hostAllowed(host) {
+		return fmt.Errorf("%w: %s resolves to %s outside allowedCidrs", ErrSourceNotAllowed, host, addr)
+	}
+
+	return nil
+}
+
+// CheckHost resolves host and checks every address it has against the policy.
+// Requests sent through a proxy are checked with it,
+// since the proxy connects to the host rather than us.
+func (p *SourcePolicy) CheckHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := p.CheckAddr(host, addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dialContext resolves the host itself so every address is checked against the policy
+// and the connection is made to the address that was checked.
+// Connections to proxyAddr are trusted since the operator configured the proxy,
+// and the hosts requested through it are checked by policyTransport instead.
+func (p *SourcePolicy) dialContext(dialer *net.Dialer, proxyAddr string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if proxyAddr != "" && address == proxyAddr {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+
+		var dialErr error
+		for _, addr := range addrs {
+			if err := p.CheckAddr(host, addr); err != nil {
+				return nil, err
+			}
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+		}
+		if dialErr == nil {
+			dialErr = fmt.Errorf("no addresses found for %s", host)
+		}
+
+		return nil, dialErr
+	}
+}
+
+// policyTransport applies the policy to every request, including each redirect hop.
+// When requests are sent through a proxy, their host's addresses are checked before the proxy is asked to connect to it.
+type policyTransport struct {
+	policy  *SourcePolicy
+	proxied bool
+	next    http.RoundTripper
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.CheckURL(req.URL); err != nil {
+		return nil, err
+	}
+	if t.proxied {
+		if err := t.policy.CheckHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
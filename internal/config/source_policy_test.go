@@ -0,0 +1,206 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourcePolicy_CheckURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *SourcePolicy
+		uri       string
+		wantError bool
+	}{
+		{
+			name:   "no policy allows https",
+			policy: nil,
+			uri:    "https://islandora.dev/_flysystem/fedora/foo.tiff",
+		},
+		{
+			name:      "no policy denies file scheme",
+			policy:    nil,
+			uri:       "file:///etc/passwd",
+			wantError: true,
+		},
+		{
+			name:      "scheme not in allowedSchemes",
+			policy:    &SourcePolicy{AllowedSchemes: []string{"https"}},
+			uri:       "http://islandora.dev/foo.tiff",
+			wantError: true,
+		},
+		{
+			name:   "exact host",
+			policy: &SourcePolicy{AllowedHosts: []string{"islandora.dev"}},
+			uri:    "https://islandora.dev/foo.tiff",
+		},
+		{
+			name:   "wildcard host",
+			policy: &SourcePolicy{AllowedHosts: []string{"*.islandora.dev"}},
+			uri:    "https://files.islandora.dev/foo.tiff",
+		},
+		{
+			name:      "wildcard does not match apex",
+			policy:    &SourcePolicy{AllowedHosts: []string{"*.islandora.dev"}},
+			uri:       "https://islandora.dev/foo.tiff",
+			wantError: true,
+		},
+		{
+			name:      "host not allowed",
+			policy:    &SourcePolicy{AllowedHosts: []string{"islandora.dev"}},
+			uri:       "https://evil.example/foo.tiff",
+			wantError: true,
+		},
+		{
+			name:   "cidr allowlist defers to resolved address",
+			policy: &SourcePolicy{AllowedCIDRs: []string{"10.0.0.0/8"}},
+			uri:    "https://drupal/foo.tiff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.uri)
+			require.NoError(t, err)
+
+			err = tt.policy.CheckURL(u)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrSourceNotAllowed)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSourcePolicy_CheckAddr(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *SourcePolicy
+		host      string
+		addr      string
+		wantError bool
+	}{
+		{
+			name: "public address",
+			host: "islandora.dev",
+			addr: "93.184.216.34",
+		},
+		{
+			name:      "loopback denied by default",
+			host:      "localhost",
+			addr:      "127.0.0.1",
+			wantError: true,
+		},
+		{
+			name:      "ipv6 loopback denied by default",
+			host:      "localhost",
+			addr:      "::1",
+			wantError: true,
+		},
+		{
+			name:      "cloud metadata denied by default",
+			host:      "metadata.google.internal",
+			addr:      "169.254.169.254",
+			wantError: true,
+		},
+		{
+			name:      "ipv4 mapped link-local denied",
+			host:      "metadata",
+			addr:      "::ffff:169.254.169.254",
+			wantError: true,
+		},
+		{
+			name:      "allowed host resolving to loopback still denied",
+			policy:    &SourcePolicy{AllowedHosts: []string{"localhost"}},
+			host:      "localhost",
+			addr:      "127.0.0.1",
+			wantError: true,
+		},
+		{
+			name:   "loopback allowed by cidr",
+			policy: &SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+			host:   "localhost",
+			addr:   "127.0.0.1",
+		},
+		{
+			name:      "address outside cidr allowlist",
+			policy:    &SourcePolicy{AllowedCIDRs: []string{"10.0.0.0/8"}},
+			host:      "drupal",
+			addr:      "192.168.1.10",
+			wantError: true,
+		},
+		{
+			name:   "allowed host outside cidr allowlist",
+			policy: &SourcePolicy{AllowedHosts: []string{"drupal"}, AllowedCIDRs: []string{"10.0.0.0/8"}},
+			host:   "drupal",
+			addr:   "192.168.1.10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckAddr(tt.host, netip.MustParseAddr(tt.addr))
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrSourceNotAllowed)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSourcePolicy_Validate(t *testing.T) {
+	assert.NoError(t, (*SourcePolicy)(nil).Validate())
+	assert.NoError(t, (&SourcePolicy{AllowedCIDRs: []string{"10.0.0.0/8", "fd00::/8"}}).Validate())
+	assert.Error(t, (&SourcePolicy{AllowedCIDRs: []string{"10.0.0.0"}}).Validate())
+}
+
+func TestSourceClient(t *testing.T) {
+	var gotAuth string
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer source.Close()
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	t.Run("loopback denied by default", func(t *testing.T) {
+		c := &ServerConfig{}
+		req, err := http.NewRequest("GET", source.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		_, err = c.SourceClient().Do(req)
+		assert.ErrorIs(t, err, ErrSourceNotAllowed)
+		assert.Empty(t, gotAuth)
+	})
+
+	t.Run("loopback allowed by cidr", func(t *testing.T) {
+		c := &ServerConfig{SourcePolicy: &SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}}}
+		req, err := http.NewRequest("GET", source.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := c.SourceClient().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "Bearer secret", gotAuth)
+	})
+
+	t.Run("redirect to link-local denied", func(t *testing.T) {
+		c := &ServerConfig{SourcePolicy: &SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}}}
+		_, err := c.SourceClient().Get(redirect.URL)
+		assert.ErrorIs(t, err, ErrSourceNotAllowed)
+	})
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region   = "us-east-1"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3SourceConfig configures access to S3 compatible storage for s3://bucket/key source URIs.
+//
+// swagger:model S3SourceConfig
+type S3SourceConfig struct {
+	// Endpoint URL of the S3 API, e.g. http://minio:9000.
+	//
+	// required: false
+	// default: https://s3.{region}.amazonaws.com
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Region used to sign requests.
+	//
+	// required: false
+	// default: $AWS_REGION or us-east-1
+	Region string `yaml:"region,omitempty"`
+
+	// Access key ID used to sign requests.
+	// If no credentials are set, requests are sent unsigned.
+	//
+	// required: false
+	// default: $AWS_ACCESS_KEY_ID
+	AccessKeyID string `yaml:"accessKeyId,omitempty"`
+
+	// Secret access key used to sign requests.
+	//
+	// required: false
+	// default: $AWS_SECRET_ACCESS_KEY
+	SecretAccessKey string `yaml:"secretAccessKey,omitempty"`
+
+	// Session token for temporary credentials.
+	//
+	// required: false
+	// default: $AWS_SESSION_TOKEN
+	SessionToken string `yaml:"sessionToken,omitempty"`
+
+	// Address buckets as a path on the endpoint instead of as a subdomain.
+	// Most S3 compatible services like MinIO need this.
+	//
+	// required: false
+	// default: false
+	UsePathStyle bool `yaml:"usePathStyle,omitempty"`
+
+	// Buckets source files may be read from. If empty, any bucket is allowed.
+	//
+	// required: false
+	Buckets []string `yaml:"buckets,omitempty"`
+}
+
+// Validate checks the S3 source config for configuration errors.
+func (s *S3SourceConfig) Validate() error {
+	if s == nil || s.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid s3 endpoint %q", s.Endpoint)
+	}
+
+	return nil
+}
+
+// s3Resolver opens s3:// source URIs with signed GET requests to the configured endpoint.
+type s3Resolver struct {
+	config    S3SourceConfig
+	endpoint  *url.URL
+	client    *http.Client
+	now       func() time.Time
+	configErr error
+}
+
+func newS3Resolver(s *S3SourceConfig, f *FetchConfig) *s3Resolver {
+	r := &s3Resolver{now: time.Now}
+	if s != nil {
+		r.config = *s
+	}
+	if r.config.Region == "" {
+		r.config.Region = os.Getenv("AWS_REGION")
+	}
+	if r.config.Region == "" {
+		r.config.Region = defaultS3Region
+	}
+	if r.config.AccessKeyID == "" && r.config.SecretAccessKey == "" {
+		r.config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		r.config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		r.config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if r.config.Endpoint == "" {
+		r.config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", r.config.Region)
+	}
+
+	r.endpoint, r.configErr = url.Parse(r.config.Endpoint)
+	if r.configErr != nil {
+		return r
+	}
+
+	if f == nil {
+		f = &FetchConfig{}
+	}
+	// the endpoint is configured by the operator, so it isn't subject to the source policy
+	transport, err := newFetchTransport(f, func(dialer *net.Dialer, proxyAddr string) func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext
+	})
+	if err != nil {
+		r.configErr = err
+		return r
+	}
+	r.client = &http.Client{
+		Timeout: f.Timeout,
+		Transport: &fetchTransport{
+			config: f,
+			next:   transport,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return r
+}
+
+func (s *s3Resolver) Open(ctx context.Context, u *url.URL, auth string) (*Source, error) {
+	if s.configErr != nil {
+		return nil, s.configErr
+	}
+
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%w: s3 URI must be s3://bucket/key", ErrInvalidSource)
+	}
+	if len(s.config.Buckets) > 0 && !slices.Contains(s.config.Buckets, bucket) {
+		return nil, fmt.Errorf("%w: bucket %q", ErrSourceNotAllowed, bucket)
+	}
+
+	objectURL := *s.endpoint
+	objectPath := strings.TrimSuffix(objectURL.Path, "/")
+	if s.config.UsePathStyle {
+		objectPath += "/" + bucket
+	} else {
+		objectURL.Host = bucket + "." + objectURL.Host
+	}
+	objectURL.Path = objectPath + "/" + key
+	objectURL.RawPath = s3EscapePath(objectPath) + "/" + s3EscapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", objectURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	s.sign(req, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: s3 returned status %d for %s", ErrSourceUnavailable, resp.StatusCode, u.String())
+	}
+	slog.Debug("S3 request created for source", "url", req.URL.String())
+
+	src := &Source{
+		Body:     resp.Body,
+		MimeType: resp.Header.Get("Content-Type"),
+		Size:     resp.ContentLength,
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		src.Version = u.String() + " " + etag
+	}
+	// objects uploaded without a type are served as octet-stream
+	switch baseMimeType(src.MimeType) {
+	case "", "binary/octet-stream", "application/octet-stream":
+		if err := src.guessMimeType(key); err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+
+	return src, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to the request,
+// signing the host and any headers already set on the request.
+func (s *s3Resolver) sign(req *http.Request, now time.Time) {
+	if s.config.AccessKeyID == "" {
+		return
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	}
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath URI encodes each segment of an object path the way SigV4 expects,
+// leaving only unreserved characters and slashes as is.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSource(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+
+		// only report the type on HEAD when asked to
+		if r.Method == http.MethodHead || r.URL.Query().Get("untyped") == "" {
+			w.Header().Set("Content-Type", "image/tiff")
+		} else {
+			// stop net/http from sniffing a type for us
+			w.Header()["Content-Type"] = nil
+		}
+		if r.URL.Query().Get("status") != "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("tiff"))
+	}))
+	defer source.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		uri         string
+		body        string
+		contentType string
+		wantStatus  int
+		wantMime    string
+		wantBody    string
+		wantMethods []string
+	}{
+		{
+			name:        "single GET",
+			method:      "GET",
+			uri:         source.URL,
+			wantStatus:  http.StatusOK,
+			wantMime:    "image/tiff",
+			wantBody:    "tiff",
+			wantMethods: []string{"GET"},
+		},
+		{
+			name:        "HEAD fallback when GET has no Content-Type",
+			method:      "GET",
+			uri:         source.URL + "?untyped=1",
+			wantStatus:  http.StatusOK,
+			wantMime:    "image/tiff",
+			wantBody:    "tiff",
+			wantMethods: []string{"GET", "HEAD"},
+		},
+		{
+			name:        "bad status",
+			method:      "GET",
+			uri:         source.URL + "?status=404",
+			wantStatus:  http.StatusFailedDependency,
+			wantMethods: []string{"GET"},
+		},
+		{
+			name:        "POST streams the request body",
+			method:      "POST",
+			body:        "posted",
+			contentType: "text/plain",
+			wantStatus:  http.StatusOK,
+			wantMime:    "text/plain",
+			wantBody:    "posted",
+		},
+		{
+			name:       "no source",
+			method:     "GET",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods = nil
+			fa := true
+			c := &ServerConfig{ForwardAuth: &fa, SourcePolicy: loopbackPolicy}
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = tt.uri
+
+			src, status, err := c.OpenSource(req, message, "")
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantMethods, methods)
+			if tt.wantStatus != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer src.Close()
+
+			assert.Equal(t, tt.wantMime, src.MimeType)
+			if tt.wantBody == "" {
+				assert.Nil(t, src.Body)
+				return
+			}
+			body, err := io.ReadAll(src.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+			assert.Equal(t, int64(len(tt.wantBody)), src.Size)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// ErrDerivativeExists is returned when a derivative's file_upload_uri is a file that already exists.
+// Derivatives are never written over existing files, so a request can't replace the stream wrapper's other files.
+var ErrDerivativeExists = errors.New("derivative already exists")
+
+// StreamWrapper maps a Drupal stream wrapper scheme, e.g. private://, to the directory it stores files in.
+//
+// swagger:model StreamWrapper
+type StreamWrapper struct {
+	// Absolute path of the directory the stream wrapper's files are stored in,
+	// e.g. Drupal's private file system path mounted into the container.
+	//
+	// required: true
+	Root string `yaml:"root"`
+
+	// Write derivatives whose file_upload_uri uses this stream wrapper directly to disk
+	// instead of returning them in the response.
+	//
+	// required: false
+	// default: false
+	WriteDerivatives bool `yaml:"writeDerivatives,omitempty"`
+}
+
+// validateStreamWrappers checks the stream wrapper config for configuration errors.
+func validateStreamWrappers(wrappers map[string]StreamWrapper) error {
+	for scheme, w := range wrappers {
+		switch scheme {
+		case "http", "https", "file", "s3", "data":
+			return fmt.Errorf("stream wrapper %q conflicts with a built-in source scheme", scheme)
+		}
+		if !filepath.IsAbs(w.Root) {
+			return fmt.Errorf("stream wrapper %q root %q must be an absolute path", scheme, w.Root)
+		}
+	}
+
+	return nil
+}
+
+// streamWrapperTarget splits a stream wrapper URI like private://2024-03/thumbnail.jpg
+// into the configured stream wrapper and the file's path relative to its root.
+// Drupal doesn't URL encode these URIs, so they're split as is rather than parsed as URLs.
+func (c *ServerConfig) streamWrapperTarget(uri string) (StreamWrapper, string, bool) {
+	scheme, target, ok := strings.Cut(uri, "://")
+	if !ok {
+		return StreamWrapper{}, "", false
+	}
+	w, ok := c.StreamWrappers[strings.ToLower(scheme)]
+	if !ok {
+		return StreamWrapper{}, "", false
+	}
+
+	return w, path.Clean("/" + target)[1:], true
+}
+
+// Derivative is a derivative being written directly to a stream wrapper's directory.
+// The command's output is written to a temporary file that's moved into place on Commit,
+// so a failed command never leaves a partial derivative behind.
+type Derivative struct {
+	*os.File
+
+	// URI is the file_upload_uri the derivative is written to.
+	URI string
+
+	root *os.Root
+	rel  string
+	tmp  string
+}
+
+// CreateDerivative starts writing the message's derivative to disk when its file_upload_uri uses a
+// stream wrapper with writeDerivatives enabled. It returns nil if the derivative should be sent in the response instead.
+func (c *ServerConfig) CreateDerivative(message api.Payload) (*Derivative, error) {
+	uri := message.Attachment.Content.FileUploadURI
+	w, rel, ok := c.streamWrapperTarget(uri)
+	if !ok || !w.WriteDerivatives {
+		return nil, nil
+	}
+	if rel == "" {
+		return nil, fmt.Errorf("%w: file upload URI %q has no path", ErrInvalidSource, uri)
+	}
+	rel = filepath.FromSlash(rel)
+
+	root, err := os.OpenRoot(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	// don't run the command for a derivative that can't be committed
+	if _, err := root.Lstat(rel); !errors.Is(err, fs.ErrNotExist) {
+		root.Close()
+		if err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrDerivativeExists, uri)
+		}
+		return nil, err
+	}
+	if dir := filepath.Dir(rel); dir != "." {
+		if err := root.MkdirAll(dir, 0775); err != nil {
+			root.Close()
+			return nil, err
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(rel), fmt.Sprintf(".%s.%s.tmp", filepath.Base(rel), rand.Text()))
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	return &Derivative{
+		File: f,
+		URI:  uri,
+		root: root,
+		rel:  rel,
+		tmp:  tmp,
+	}, nil
+}
+
+// Commit moves the finished derivative into place.
+// It fails with ErrDerivativeExists if a file was created at its path in the meantime,
+// which is left as it is.
+func (d *Derivative) Commit() error {
+	defer d.root.Close()
+
+	tmp := d.tmp
+	if err := d.Sync(); err != nil {
+		d.Close()
+		_ = d.root.Remove(tmp)
+		return err
+	}
+	if err := d.Close(); err != nil {
+		_ = d.root.Remove(tmp)
+		return err
+	}
+	// unlike renaming, linking fails rather than replacing an existing file
+	err := d.root.Link(tmp, d.rel)
+	_ = d.root.Remove(tmp)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrDerivativeExists, d.URI)
+	}
+
+	return err
+}
+
+// Abort discards the partially written derivative.
+func (d *Derivative) Abort() error {
+	defer d.root.Close()
+	d.Close()
+
+	return d.root.Remove(d.tmp)
+}
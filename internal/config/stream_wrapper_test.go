@@ -0,0 +1,195 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSource_StreamWrapper(t *testing.T) {
+	private := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(private, "2024-03"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(private, "2024-03", "my file.pdf"), []byte("%PDF-1.7"), 0600))
+
+	tests := []struct {
+		name       string
+		schemes    []string
+		uri        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "file in stream wrapper",
+			uri:        "private://2024-03/my file.pdf",
+			wantStatus: http.StatusOK,
+			wantBody:   "%PDF-1.7",
+		},
+		{
+			name:       "scheme not allowed",
+			schemes:    []string{"https"},
+			uri:        "private://2024-03/my file.pdf",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "traversal stays in root",
+			uri:        "private://../../2024-03/my file.pdf",
+			wantStatus: http.StatusOK,
+			wantBody:   "%PDF-1.7",
+		},
+		{
+			name:       "missing file",
+			uri:        "private://2024-03/missing.pdf",
+			wantStatus: http.StatusFailedDependency,
+		},
+		{
+			name:       "unmapped stream wrapper",
+			uri:        "public://2024-03/my file.pdf",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemes := tt.schemes
+			if schemes == nil {
+				schemes = []string{"private", "public"}
+			}
+			c := &ServerConfig{
+				SourcePolicy: &SourcePolicy{AllowedSchemes: schemes},
+				StreamWrappers: map[string]StreamWrapper{
+					"private": {Root: private},
+				},
+			}
+			message := api.Payload{}
+			message.Attachment.Content.SourceURI = tt.uri
+
+			src, status, err := c.OpenSource(httptest.NewRequest("GET", "/", nil), message, "")
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer src.Close()
+
+			assert.Equal(t, "application/pdf", src.MimeType)
+			body, err := io.ReadAll(src.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func TestCreateDerivative(t *testing.T) {
+	private := t.TempDir()
+	public := t.TempDir()
+	c := &ServerConfig{
+		StreamWrappers: map[string]StreamWrapper{
+			"private": {Root: private, WriteDerivatives: true},
+			"public":  {Root: public},
+		},
+	}
+	message := api.Payload{}
+
+	t.Run("not written when disabled", func(t *testing.T) {
+		message.Attachment.Content.FileUploadURI = "public://2024-03/thumbnail.jpg"
+		d, err := c.CreateDerivative(message)
+		require.NoError(t, err)
+		assert.Nil(t, d)
+	})
+
+	t.Run("not written for other URIs", func(t *testing.T) {
+		message.Attachment.Content.FileUploadURI = "fedora://2024-03/thumbnail.jpg"
+		d, err := c.CreateDerivative(message)
+		require.NoError(t, err)
+		assert.Nil(t, d)
+	})
+
+	t.Run("committed", func(t *testing.T) {
+		message.Attachment.Content.FileUploadURI = "private://2024-03/thumbnail.jpg"
+		d, err := c.CreateDerivative(message)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+
+		_, err = d.Write([]byte("jpeg"))
+		require.NoError(t, err)
+		// nothing is in place until the derivative is committed
+		assert.NoFileExists(t, filepath.Join(private, "2024-03", "thumbnail.jpg"))
+
+		require.NoError(t, d.Commit())
+		b, err := os.ReadFile(filepath.Join(private, "2024-03", "thumbnail.jpg"))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", string(b))
+		entries, err := os.ReadDir(filepath.Join(private, "2024-03"))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		message.Attachment.Content.FileUploadURI = "private://2024-04/thumbnail.jpg"
+		d, err := c.CreateDerivative(message)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+
+		_, err = d.Write([]byte("partial"))
+		require.NoError(t, err)
+		require.NoError(t, d.Abort())
+		entries, err := os.ReadDir(filepath.Join(private, "2024-04"))
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("existing files aren't overwritten", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(private, "2024-05"), 0o755))
+		source := filepath.Join(private, "2024-05", "book.pdf")
+		require.NoError(t, os.WriteFile(source, []byte("pdf"), 0o644))
+
+		message.Attachment.Content.FileUploadURI = "private://2024-05/book.pdf"
+		_, err := c.CreateDerivative(message)
+		assert.ErrorIs(t, err, ErrDerivativeExists)
+
+		// a file created while the command runs is left alone too
+		message.Attachment.Content.FileUploadURI = "private://2024-05/thumbnail.jpg"
+		d, err := c.CreateDerivative(message)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		_, err = d.Write([]byte("jpeg"))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(private, "2024-05", "thumbnail.jpg"), []byte("other"), 0o644))
+		assert.ErrorIs(t, d.Commit(), ErrDerivativeExists)
+
+		b, err := os.ReadFile(source)
+		require.NoError(t, err)
+		assert.Equal(t, "pdf", string(b))
+		b, err = os.ReadFile(filepath.Join(private, "2024-05", "thumbnail.jpg"))
+		require.NoError(t, err)
+		assert.Equal(t, "other", string(b))
+		entries, err := os.ReadDir(filepath.Join(private, "2024-05"))
+		require.NoError(t, err)
+		assert.Len(t, entries, 2, "the temporary file is removed")
+	})
+
+	t.Run("symlinks can't escape the root", func(t *testing.T) {
+		outside := t.TempDir()
+		require.NoError(t, os.Symlink(outside, filepath.Join(private, "escape")))
+		message.Attachment.Content.FileUploadURI = "private://escape/thumbnail.jpg"
+		_, err := c.CreateDerivative(message)
+		assert.Error(t, err)
+		entries, err := os.ReadDir(outside)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestValidateStreamWrappers(t *testing.T) {
+	assert.NoError(t, validateStreamWrappers(map[string]StreamWrapper{"private": {Root: "/var/www/drupal/private"}}))
+	assert.Error(t, validateStreamWrappers(map[string]StreamWrapper{"private": {Root: "private"}}))
+	assert.Error(t, validateStreamWrappers(map[string]StreamWrapper{"https": {Root: "/var/www"}}))
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// templateFuncs are the helper functions available to argument templates.
+var templateFuncs = template.FuncMap{
+	"mimeExt": GetMimeTypeExtension,
+	"pandoc":  MimeToPandoc,
+	"urlByRel": func(rel string, links []api.Link) string {
+		for _, u := range links {
+			if u.Rel == rel {
+				return u.Href
+			}
+		}
+		return ""
+	},
+}
+
+// parseArgTemplate parses a command argument as a text/template.
+// Referencing a missing map key, like an absent JWT claim, is an error
+// rather than rendering as "<no value>".
+func parseArgTemplate(a string) (*template.Template, error) {
+	t, err := template.New("arg").Funcs(templateFuncs).Option("missingkey=error").Parse(a)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument template %q: %w", a, err)
+	}
+
+	return t, nil
+}
+
+// renderArgTemplate renders the command's ith argument template for message.
+// An argument that renders empty is dropped, so {{if}} can leave out optional arguments.
+// Commands that weren't validated, like ones built in code, have their template parsed here.
+func (c Command) renderArgTemplate(i int, message api.Payload) ([]string, error) {
+	var t *template.Template
+	if i < len(c.argTemplates) && c.argTemplates[i] != nil {
+		t = c.argTemplates[i]
+	} else {
+		var err error
+		if t, err = parseArgTemplate(c.Args[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// the Authorization header is only passed in SCYLLARIDAE_AUTH,
+	// as other processes can read a command's arguments
+	message.Authorization = ""
+
+	var b strings.Builder
+	if err := t.Execute(&b, message); err != nil {
+		return nil, fmt.Errorf("unable to render argument %q: %w", c.Args[i], err)
+	}
+	if b.Len() == 0 {
+		return nil, nil
+	}
+
+	return []string{b.String()}, nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildExecCommand_ArgsTemplate(t *testing.T) {
+	message := api.Payload{
+		Actor:   api.Actor{ID: "urn:uuid:actor"},
+		Summary: "Generate a thumbnail",
+		Object: api.Object{
+			IsNewVersion: true,
+			URL: []api.Link{
+				{Rel: "canonical", Href: "https://example.com/node/1"},
+				{Rel: "alternate", Href: "https://example.com/node/1?_format=jsonld"},
+			},
+		},
+		Claims:        map[string]any{"sub": "admin", "roles": []any{"editor"}},
+		Authorization: "Bearer secret",
+	}
+	message.Attachment.Content.SourceField = "field_media_image"
+	message.Attachment.Content.SourceMimeType = "application/pdf"
+	message.Attachment.Content.DestinationMimeType = "image/webp"
+	message.Attachment.Content.Args = "-quality 80"
+
+	tests := []struct {
+		name      string
+		args      []string
+		message   api.Payload
+		want      []string
+		wantError bool
+	}{
+		{
+			name: "payload fields",
+			args: []string{
+				"--actor={{.Actor.ID}}",
+				"{{.Summary}}",
+				"--field={{.Attachment.Content.SourceField}}",
+			},
+			message: message,
+			want:    []string{"--actor=urn:uuid:actor", "Generate a thumbnail", "--field=field_media_image"},
+		},
+		{
+			name: "helpers",
+			args: []string{
+				"{{mimeExt .Attachment.Content.SourceMimeType}}:-[0]",
+				"{{.Attachment.Content.DestinationMimeType | mimeExt}}:-",
+				"{{pandoc \"text/markdown\"}}",
+				"{{.Object.URL | urlByRel \"alternate\"}}",
+			},
+			message: message,
+			want:    []string{"pdf:-[0]", "webp:-", "markdown", "https://example.com/node/1?_format=jsonld"},
+		},
+		{
+			name:    "conditional arguments",
+			args:    []string{"{{if .Object.IsNewVersion}}--new-version{{end}}", "{{if not .Object.IsNewVersion}}--old{{end}}", "-"},
+			message: message,
+			want:    []string{"--new-version", "-"},
+		},
+		{
+			name:    "one argument per template",
+			args:    []string{"{{.Summary}}; rm -rf /"},
+			message: message,
+			want:    []string{"Generate a thumbnail; rm -rf /"},
+		},
+		{
+			name:    "JWT claims",
+			args:    []string{"--user={{.Claims.sub}}", "{{with index .Claims \"email\"}}--email={{.}}{{end}}", "{{range .Claims.roles}}{{.}}{{end}}"},
+			message: message,
+			want:    []string{"--user=admin", "editor"},
+		},
+		{
+			name:    "%args",
+			args:    []string{"-", "%args", "out.{{mimeExt .Attachment.Content.DestinationMimeType}}"},
+			message: message,
+			want:    []string{"-", "-quality", "80", "out.webp"},
+		},
+		{
+			name:    "placeholders aren't replaced",
+			args:    []string{"%canonical"},
+			message: message,
+			want:    []string{"%canonical"},
+		},
+		{
+			name:    "no Authorization header",
+			args:    []string{"-", "{{.Authorization}}"},
+			message: message,
+			want:    []string{"-"},
+		},
+		{
+			name:      "missing claim",
+			args:      []string{"{{.Claims.email}}"},
+			message:   message,
+			wantError: true,
+		},
+		{
+			name:      "helper error",
+			args:      []string{"{{pandoc \"application/x-unknown\"}}"},
+			message:   message,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := Command{Cmd: "cat", Args: tt.args, ArgsTemplate: true}
+			require.NoError(t, command.Validate())
+			forwardAuth := false
+			c := &ServerConfig{
+				ForwardAuth:      &forwardAuth,
+				AllowedMimeTypes: []string{"*"},
+				CmdByMimeType:    map[string]Command{"default": command},
+			}
+
+			cmd, err := BuildExecCommand(tt.message, c)
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cmd.Args[1:])
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package redact removes secrets from log messages.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/islandora/scyllaridae/internal/config"
+)
+
+// Redacted replaces secrets in log messages.
+const Redacted = "[REDACTED]"
+
+// minSecretLen is the shortest secret value that's redacted,
+// so short values don't redact common words out of every message.
+const minSecretLen = 4
+
+// queryParams are always redacted from URLs, as they're used to sign them.
+var queryParams = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"access_token",
+	"token",
+	"signature",
+}
+
+var (
+	bearerToken = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	jwt         = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+)
+
+// Redactor removes secrets from strings.
+// Besides the rules it was created with, it redacts the values being tracked for requests in progress.
+type Redactor struct {
+	params   *regexp.Regexp
+	patterns []*regexp.Regexp
+
+	mu     sync.RWMutex
+	values map[string]int
+	sorted []string
+}
+
+// New creates a Redactor for the configured rules, which may be nil.
+// The rules must have been validated.
+func New(rules *config.RedactConfig) *Redactor {
+	r := &Redactor{values: map[string]int{}}
+	params := slices.Clone(queryParams)
+	if rules != nil {
+		params = append(params, rules.QueryParams...)
+		for _, p := range rules.Patterns {
+			r.patterns = append(r.patterns, regexp.MustCompile(p))
+		}
+	}
+
+	quoted := make([]string, len(params))
+	for i, p := range params {
+		quoted[i] = regexp.QuoteMeta(p)
+	}
+	r.params = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(quoted, "|") + `)=)[^&#\s"']*`)
+
+	return r
+}
+
+// Track redacts values until the returned function is called.
+func (r *Redactor) Track(values ...string) func() {
+	tracked := []string{}
+	for _, v := range values {
+		if len(v) >= minSecretLen {
+			tracked = append(tracked, v)
+		}
+	}
+	if len(tracked) == 0 {
+		return func() {}
+	}
+
+	r.mu.Lock()
+	for _, v := range tracked {
+		r.values[v]++
+	}
+	r.sort()
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, v := range tracked {
+				r.values[v]--
+				if r.values[v] <= 0 {
+					delete(r.values, v)
+				}
+			}
+			r.sort()
+		})
+	}
+}
+
+// sort orders the tracked values longest first, so a secret containing another is redacted whole.
+func (r *Redactor) sort() {
+	r.sorted = r.sorted[:0]
+	for v := range r.values {
+		r.sorted = append(r.sorted, v)
+	}
+	slices.SortFunc(r.sorted, func(a, b string) int {
+		return len(b) - len(a)
+	})
+}
+
+// String returns s with its secrets redacted.
+func (r *Redactor) String(s string) string {
+	r.mu.RLock()
+	for _, v := range r.sorted {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	r.mu.RUnlock()
+
+	s = bearerToken.ReplaceAllString(s, "${1}"+Redacted)
+	s = jwt.ReplaceAllString(s, Redacted)
+	s = r.params.ReplaceAllString(s, "${1}"+Redacted)
+	for _, p := range r.patterns {
+		s = p.ReplaceAllString(s, Redacted)
+	}
+
+	return s
+}
+
+// Handler returns a slog.Handler that redacts every message and attribute before passing it to h.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &handler{h: h, r: r}
+}
+
+type handler struct {
+	h slog.Handler
+	r *Redactor
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.r.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.attr(a))
+		return true
+	})
+
+	return h.h.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+
+	return &handler{h: h.h.WithAttrs(redacted), r: h.r}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{h: h.h.WithGroup(name), r: h.r}
+}
+
+// attr redacts an attribute's value, formatting anything that could hold a secret as a string.
+func (h *handler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.r.String(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]any, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = h.attr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch value := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, h.r.String(value.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, h.r.String(value.String()))
+		default:
+			return slog.String(a.Key, h.r.String(fmt.Sprintf("%+v", value)))
+		}
+	}
+
+	return slog.Attr{Key: a.Key, Value: v}
+}
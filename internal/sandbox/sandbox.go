@@ -0,0 +1,60 @@
+// Package sandbox runs commands with resource limits, as a different user
+// and with a read-only view of the filesystem.
+//
+// exec.Cmd can't set rlimits or mounts for the process it starts,
+// so sandboxed commands are started through the scyllaridae binary itself,
+// which applies the sandbox to its own process before replacing itself with the command.
+package sandbox
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/islandora/scyllaridae/internal/config"
+)
+
+// HelperArg is the argument the scyllaridae binary is started with to run a sandboxed command.
+// main must call Exec when it's the first argument.
+const HelperArg = "__sandbox"
+
+// specEnv passes the sandbox to apply from Wrap to Exec.
+const specEnv = "SCYLLARIDAE_SANDBOX"
+
+// spec is the sandbox Exec applies before running the command.
+type spec struct {
+	Sandbox *config.SandboxConfig
+	Dir     string
+}
+
+// Wrap changes cmd to run inside the sandbox, in a temporary directory of its own.
+// The returned function removes the temporary directory and must be called once the command has exited.
+func Wrap(cmd *exec.Cmd, cfg *config.SandboxConfig) (func(), error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	// the command is run from its temporary directory
+	path, err := filepath.Abs(cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(cfg.TempDir, "scyllaridae-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary directory: %w", err)
+	}
+	cleanup, err := wrap(cmd, cfg, path, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return func() {
+		cleanup()
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("Unable to remove sandbox temporary directory", "dir", dir, "err", err)
+		}
+	}, nil
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/islandora/scyllaridae/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// cpuPeriod is the cgroup cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+func wrap(cmd *exec.Cmd, cfg *config.SandboxConfig, path, dir string) (func(), error) {
+	if cfg.UID != nil || cfg.GID != nil {
+		uid, gid := -1, -1
+		if cfg.UID != nil {
+			uid = *cfg.UID
+		}
+		if cfg.GID != nil {
+			gid = *cfg.GID
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return nil, fmt.Errorf("unable to chown temporary directory: %w", err)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	s, err := json.Marshal(spec{Sandbox: cfg, Dir: dir})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Env = append(cmd.Environ(), "TMPDIR="+dir, specEnv+"="+string(s))
+	cmd.Dir = dir
+	cmd.Args = append([]string{exe, HelperArg, path}, cmd.Args...)
+	cmd.Path = exe
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if cfg.ReadOnly {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+
+	if cfg.MemoryBytes == 0 && cfg.CPUs == 0 {
+		return func() {}, nil
+	}
+	cg, err := newCgroup(cfg)
+	if err != nil {
+		slog.Warn("cgroup v2 limits unavailable, running command without them", "cgroupParent", cfg.Cgroup(), "err", err)
+		return func() {}, nil
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = cg.fd
+
+	return cg.remove, nil
+}
+
+// Exec applies the sandbox passed by Wrap to this process and replaces it with the command.
+// args are the command's path followed by its arguments. Exec never returns.
+func Exec(args []string) {
+	err := execSandboxed(args)
+	fmt.Fprintln(os.Stderr, "scyllaridae sandbox:", err)
+	os.Exit(126)
+}
+
+func execSandboxed(args []string) error {
+	if len(args) < 2 {
+		return errors.New("no command to run")
+	}
+	var s spec
+	if err := json.Unmarshal([]byte(os.Getenv(specEnv)), &s); err != nil || s.Sandbox == nil {
+		return fmt.Errorf("invalid %s: %v", specEnv, err)
+	}
+	os.Unsetenv(specEnv)
+	cfg := s.Sandbox
+
+	if cfg.ReadOnly {
+		if err := readOnlyExcept(s.Dir); err != nil {
+			return fmt.Errorf("unable to mount the filesystem read-only: %w", err)
+		}
+	}
+
+	for _, l := range []struct {
+		resource int
+		limit    uint64
+	}{
+		{unix.RLIMIT_CPU, cfg.CPUSeconds},
+		{unix.RLIMIT_AS, cfg.AddressSpaceBytes},
+		{unix.RLIMIT_NOFILE, cfg.OpenFiles},
+		{unix.RLIMIT_NPROC, cfg.Processes},
+	} {
+		if l.limit == 0 {
+			continue
+		}
+		// syscall.Setrlimit stops syscall.Exec restoring the soft RLIMIT_NOFILE Go started with
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.limit, Max: l.limit}); err != nil {
+			return fmt.Errorf("unable to set rlimit %d: %w", l.resource, err)
+		}
+	}
+
+	if cfg.UID != nil || cfg.GID != nil {
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("unable to drop supplementary groups: %w", err)
+		}
+	}
+	if cfg.GID != nil {
+		if err := syscall.Setgid(*cfg.GID); err != nil {
+			return fmt.Errorf("unable to set gid: %w", err)
+		}
+	}
+	if cfg.UID != nil {
+		if err := syscall.Setuid(*cfg.UID); err != nil {
+			return fmt.Errorf("unable to set uid: %w", err)
+		}
+	}
+	// don't let setuid binaries regain what we've given up
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("unable to set no_new_privs: %w", err)
+	}
+
+	return syscall.Exec(args[0], args[1:], os.Environ())
+}
+
+// readOnlyExcept makes every mount read-only except dir.
+// The process must be in a mount namespace of its own.
+func readOnlyExcept(dir string) error {
+	// keep our changes from propagating back to the server's mounts
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	// dir gets a mount of its own so it can be left writable
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+	if err := unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}); err != nil {
+		return err
+	}
+
+	return unix.MountSetattr(unix.AT_FDCWD, dir, 0, &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY})
+}
+
+// cgroup is a cgroup v2 group a single command is run in.
+type cgroup struct {
+	path string
+	fd   int
+}
+
+func newCgroup(cfg *config.SandboxConfig) (*cgroup, error) {
+	parent := cfg.Cgroup()
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", parent, err)
+	}
+	path, err := os.MkdirTemp(parent, "cmd-")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setCgroupLimits(path, cfg); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &cgroup{path: path, fd: fd}, nil
+}
+
+func setCgroupLimits(path string, cfg *config.SandboxConfig) error {
+	if cfg.MemoryBytes > 0 {
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), []byte(strconv.FormatInt(cfg.MemoryBytes, 10)), 0); err != nil {
+			return err
+		}
+		// don't let the command get around memory.max by swapping
+		_ = os.WriteFile(filepath.Join(path, "memory.swap.max"), []byte("0"), 0)
+	}
+	if cfg.CPUs > 0 {
+		quota := max(int64(cfg.CPUs*cpuPeriod), 1000)
+		if err := os.WriteFile(filepath.Join(path, "cpu.max"), fmt.Appendf(nil, "%d %d", quota, cpuPeriod), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove kills anything the command left running and removes the cgroup.
+func (c *cgroup) remove() {
+	syscall.Close(c.fd)
+	_ = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0)
+
+	var err error
+	for range 50 {
+		// the cgroup can only be removed once the killed processes have exited
+		if err = os.Remove(c.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	slog.Warn("Unable to remove cgroup", "path", c.path, "err", err)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/islandora/scyllaridae/internal/config"
+)
+
+func wrap(cmd *exec.Cmd, cfg *config.SandboxConfig, path, dir string) (func(), error) {
+	return nil, errors.New("sandbox is only supported on Linux")
+}
+
+// Exec is only supported on Linux.
+func Exec(args []string) {
+	fmt.Fprintln(os.Stderr, "scyllaridae sandbox: only supported on Linux")
+	os.Exit(126)
+}
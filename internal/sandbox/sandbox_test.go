@@ -0,0 +1,108 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the test binary stands in for scyllaridae when starting sandboxed commands
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == HelperArg {
+		Exec(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func runSandboxed(t *testing.T, cfg *config.SandboxConfig, script string) (string, string, error) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	cleanup, err := Wrap(cmd, cfg)
+	require.NoError(t, err)
+	err = cmd.Run()
+	cleanup()
+
+	return stdout.String(), stderr.String(), err
+}
+
+func TestWrap_RLimits(t *testing.T) {
+	cfg := &config.SandboxConfig{
+		CPUSeconds:        5,
+		AddressSpaceBytes: 1 << 30,
+		OpenFiles:         64,
+		TempDir:           t.TempDir(),
+	}
+	out, stderr, err := runSandboxed(t, cfg, "ulimit -t; ulimit -v; ulimit -n")
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "5\n1048576\n64\n", out)
+}
+
+func TestWrap_TempDir(t *testing.T) {
+	parent := t.TempDir()
+	cfg := &config.SandboxConfig{TempDir: parent}
+
+	out, stderr, err := runSandboxed(t, cfg, `echo "$TMPDIR"; pwd; echo "$SCYLLARIDAE_SANDBOX"; touch "$TMPDIR/scratch"`)
+	require.NoError(t, err, stderr)
+	lines := strings.Split(out, "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, parent, filepath.Dir(lines[0]))
+	assert.Equal(t, lines[0], lines[1])
+	assert.Empty(t, lines[2])
+
+	// the temporary directory is removed once the command is done
+	entries, err := os.ReadDir(parent)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWrap_User(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing user needs root")
+	}
+	nobody := 65534
+	// the user needs to be able to reach its temporary directory
+	parent := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(parent), 0755))
+	require.NoError(t, os.Chmod(parent, 0755))
+	cfg := &config.SandboxConfig{UID: &nobody, GID: &nobody, TempDir: parent}
+
+	out, stderr, err := runSandboxed(t, cfg, `id -u; id -g; id -G; touch "$TMPDIR/scratch"`)
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "65534\n65534\n65534\n", out)
+}
+
+func TestWrap_ReadOnly(t *testing.T) {
+	outside := t.TempDir()
+	cfg := &config.SandboxConfig{ReadOnly: true, TempDir: t.TempDir()}
+
+	out, stderr, err := runSandboxed(t, cfg, `touch "$TMPDIR/scratch" && echo tmp; touch "`+outside+`/file" 2>/dev/null || echo read-only`)
+	if err != nil && strings.Contains(stderr, "scyllaridae sandbox") {
+		t.Skipf("unable to create a mount namespace here: %s", stderr)
+	}
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "tmp\nread-only\n", out)
+	assert.NoFileExists(t, filepath.Join(outside, "file"))
+}
+
+func TestWrap_Cgroup(t *testing.T) {
+	parent := t.TempDir()
+	// without cgroup v2 the command still runs, just without the cgroup limits
+	cfg := &config.SandboxConfig{MemoryBytes: 64 << 20, CPUs: 0.5, CgroupParent: parent, TempDir: t.TempDir()}
+
+	out, stderr, err := runSandboxed(t, cfg, "echo ok")
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "ok\n", out)
+}
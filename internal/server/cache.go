@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+// cacheStatusHeader reports whether the response was served from the cache: HIT, MISS or BYPASS.
+const cacheStatusHeader = "X-Scyllaridae-Cache"
+
+// cacheKey identifies the output of running cmd on the source for the service at path.
+// It returns an empty key if the source's contents can't be identified, so the output can't be cached.
+func cacheKey(path string, cmd *exec.Cmd, command scyllaridae.Command, message api.Payload, src *scyllaridae.Source) string {
+	if src.Version == "" {
+		return ""
+	}
+
+	return hashKey(append([]string{path, src.Version, message.Attachment.Content.DestinationMimeType}, commandKey(cmd, command)...)...)
+}
+
+// commandKey is what determines cmd's output, other than its input:
+// its arguments and the environment variables its configuration sets, which can come from the event.
+func commandKey(cmd *exec.Cmd, command scyllaridae.Command) []string {
+	parts := slices.Clone(cmd.Args)
+	if command.Env == nil {
+		return parts
+	}
+	for _, kv := range cmd.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := command.Env.Set[name]; ok {
+			parts = append(parts, kv)
+		}
+	}
+
+	return parts
+}
+
+// hashKey hashes the parts of a key, keeping the boundaries between them.
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serveCached responds with the cached output for key, if there is any.
+func (s *Server) serveCached(w http.ResponseWriter, key string) bool {
+	f, ok := s.Cache.Open(key)
+	if !ok {
+		return false
+	}
+	defer f.Close()
+
+	w.Header().Set(cacheStatusHeader, "HIT")
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		slog.Error("Error sending cached output", "key", key, "err", err)
+	}
+
+	return true
+}
+
+// PurgeCacheHandler removes the service's cached output. If the source query parameter is set,
+// only output derived from that source URI is removed.
+// Services have their own authentication, so they can't purge each other's output.
+func (s *Server) PurgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Cache == nil {
+		http.Error(w, "Cache not enabled", http.StatusNotFound)
+		return
+	}
+
+	source := r.URL.Query().Get("source")
+	purged := s.Cache.Purge(s.path, source)
+	slog.Info("Purged cache", "service", s.path, "source", source, "entries", purged)
+	fmt.Fprintf(w, "Purged %d entries\n", purged)
+}
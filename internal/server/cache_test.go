@@ -0,0 +1,185 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/islandora/scyllaridae/internal/cache"
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHandler_Cache(t *testing.T) {
+	var requests atomic.Int32
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Query().Get("etag") != "" {
+			w.Header().Set("ETag", `"`+r.URL.Query().Get("etag")+`"`)
+		}
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	c, err := cache.New(t.TempDir(), 0)
+	require.NoError(t, err)
+	fa := true
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd:  "echo",
+					Args: []string{"%args"},
+				},
+			},
+		},
+		Cache: c,
+	}
+	router := server.SetupRouter()
+
+	tests := []struct {
+		name      string
+		source    string
+		args      string
+		wantCache string
+		wantBody  string
+		wantFetch int32
+	}{
+		{
+			name:      "first request runs the command",
+			source:    mockSource.URL + "?etag=v1",
+			args:      "derivative",
+			wantCache: "MISS",
+			wantBody:  "derivative",
+			wantFetch: 1,
+		},
+		{
+			name:      "identical request is served from the cache",
+			source:    mockSource.URL + "?etag=v1",
+			args:      "derivative",
+			wantCache: "HIT",
+			wantBody:  "derivative",
+			wantFetch: 1,
+		},
+		{
+			name:      "different args",
+			source:    mockSource.URL + "?etag=v1",
+			args:      "other",
+			wantCache: "MISS",
+			wantBody:  "other",
+			wantFetch: 1,
+		},
+		{
+			name:      "changed source",
+			source:    mockSource.URL + "?etag=v2",
+			args:      "derivative",
+			wantCache: "MISS",
+			wantBody:  "derivative",
+			wantFetch: 1,
+		},
+		{
+			name:      "source without a version",
+			source:    mockSource.URL,
+			args:      "derivative",
+			wantCache: "BYPASS",
+			wantBody:  "derivative",
+			wantFetch: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Apix-Ldp-Resource", tt.source)
+			req.Header.Set("X-Islandora-Args", tt.args)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.wantCache, rr.Header().Get(cacheStatusHeader))
+			assert.Equal(t, tt.wantBody, strings.TrimSpace(rr.Body.String()))
+			assert.Equal(t, tt.wantFetch, requests.Load())
+		})
+	}
+
+	// purge the output derived from the first source
+	req := httptest.NewRequest("DELETE", "/cache?source="+url.QueryEscape(mockSource.URL+"?etag=v1"), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Purged 2 entries\n", rr.Body.String())
+	assert.Equal(t, 1, c.Len())
+
+	req = httptest.NewRequest("DELETE", "/cache", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, "Purged 1 entries\n", rr.Body.String())
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestPurgeCacheHandler_Disabled(t *testing.T) {
+	server := &Server{Config: &scyllaridae.ServerConfig{}}
+	rr := httptest.NewRecorder()
+	server.SetupRouter().ServeHTTP(rr, httptest.NewRequest("DELETE", "/cache", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestPurgeCacheHandler_Services(t *testing.T) {
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	c, err := cache.New(t.TempDir(), 0)
+	require.NoError(t, err)
+	fa := true
+	service := func(path string) *scyllaridae.ServiceConfig {
+		return &scyllaridae.ServiceConfig{
+			Path: path,
+			ServerConfig: scyllaridae.ServerConfig{
+				ForwardAuth:      &fa,
+				AllowedMimeTypes: []string{"*"},
+				SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+				CmdByMimeType: map[string]scyllaridae.Command{
+					"default": {Cmd: "echo", Args: []string{"derivative"}},
+				},
+			},
+		}
+	}
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			Services: map[string]*scyllaridae.ServiceConfig{"a": service("/a"), "b": service("/b")},
+		},
+		Cache: c,
+	}
+	router := server.SetupRouter()
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the same command on the same source is cached separately for each service
+	assert.Equal(t, "MISS", request("GET", "/a/").Header().Get(cacheStatusHeader))
+	assert.Equal(t, "MISS", request("GET", "/b/").Header().Get(cacheStatusHeader))
+	assert.Equal(t, 2, c.Len())
+
+	rr := request("DELETE", "/a/cache")
+	assert.Equal(t, "Purged 1 entries\n", rr.Body.String())
+	assert.Equal(t, "HIT", request("GET", "/b/").Header().Get(cacheStatusHeader))
+	assert.Equal(t, "MISS", request("GET", "/a/").Header().Get(cacheStatusHeader))
+}
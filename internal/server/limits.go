@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync/atomic"
+)
+
+const (
+	metricInputTooLarge      = "scyllaridae_requests_input_too_large_total"
+	metricInputTooLargeHelp  = "Number of requests rejected for a source larger than maxInputBytes."
+	metricOutputTooLarge     = "scyllaridae_commands_output_too_large_total"
+	metricOutputTooLargeHelp = "Number of commands killed for writing more than maxOutputBytes."
+)
+
+var (
+	errInputTooLarge  = errors.New("source exceeds maxInputBytes")
+	errOutputTooLarge = errors.New("output exceeds maxOutputBytes")
+)
+
+// streamLimit kills a command once more than max bytes have passed through one of its streams.
+type streamLimit struct {
+	cmd *exec.Cmd
+	max int64
+	n   int64
+	err error
+	hit atomic.Bool
+}
+
+// add counts n more bytes, reporting whether they're within the limit.
+func (l *streamLimit) add(n int) bool {
+	l.n += int64(n)
+	if l.n <= l.max {
+		return true
+	}
+
+	if l.hit.CompareAndSwap(false, true) && l.cmd.Process != nil {
+		if err := killProcessGroup(l.cmd); err != nil {
+			slog.Debug("Unable to kill command", "cmd", l.cmd.String(), "err", err)
+		}
+	}
+	return false
+}
+
+// exceeded returns the limit's error if the command went over it.
+func (l *streamLimit) exceeded() error {
+	if l != nil && l.hit.Load() {
+		return l.err
+	}
+	return nil
+}
+
+type limitedReader struct {
+	r io.Reader
+	*streamLimit
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.add(n) {
+		return 0, r.streamLimit.err
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	*streamLimit
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if !w.add(len(p)) {
+		return 0, w.streamLimit.err
+	}
+	return w.w.Write(p)
+}
+
+// limitStreams applies the command's maxInputBytes and maxOutputBytes to cmd's stdin and stdout,
+// returning a function that reports which limit, if any, the command went over once it has run.
+func limitStreams(cmd *exec.Cmd, maxInput, maxOutput int64) func() error {
+	var in, out *streamLimit
+	if maxInput > 0 && cmd.Stdin != nil {
+		in = &streamLimit{cmd: cmd, max: maxInput, err: errInputTooLarge}
+		cmd.Stdin = &limitedReader{r: cmd.Stdin, streamLimit: in}
+	}
+	if maxOutput > 0 && cmd.Stdout != nil {
+		out = &streamLimit{cmd: cmd, max: maxOutput, err: errOutputTooLarge}
+		cmd.Stdout = &limitedWriter{w: cmd.Stdout, streamLimit: out}
+	}
+
+	if in != nil || out != nil {
+		// anything the command starts is killed with it,
+		// so nothing is left holding its stderr open once it's over a limit
+		newProcessGroup(cmd)
+	}
+
+	return func() error {
+		if err := in.exceeded(); err != nil {
+			return err
+		}
+		return out.exceeded()
+	}
+}
+
+// countOverLimit counts a command that went over one of its limits.
+func (s *Server) countOverLimit(err error) {
+	if errors.Is(err, errInputTooLarge) {
+		s.Metrics.Counter(metricInputTooLarge, metricInputTooLargeHelp).Inc()
+		return
+	}
+	s.Metrics.Counter(metricOutputTooLarge, metricOutputTooLargeHelp).Inc()
+}
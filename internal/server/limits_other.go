@@ -0,0 +1,11 @@
+//go:build !unix
+
+package server
+
+import "os/exec"
+
+func newProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
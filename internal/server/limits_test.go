@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHandler_SizeLimits(t *testing.T) {
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		// flush before writing the body so it's sent without a Content-Length
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("a", 1000)))
+	}))
+	defer mockSource.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		script     string
+		maxInput   int64
+		maxOutput  int64
+		wantStatus int
+		wantBody   string
+		wantMetric string
+	}{
+		{
+			name:       "POST under the limits",
+			method:     "POST",
+			body:       "small",
+			script:     "cat",
+			maxInput:   10,
+			maxOutput:  10,
+			wantStatus: http.StatusOK,
+			wantBody:   "small",
+		},
+		{
+			name:       "POST larger than maxInputBytes",
+			method:     "POST",
+			body:       "this is too large",
+			script:     "cat",
+			maxInput:   10,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "Request Entity Too Large\n",
+			wantMetric: metricInputTooLarge + " 1\n",
+		},
+		{
+			name:       "streamed source larger than maxInputBytes",
+			method:     "GET",
+			script:     "cat > /dev/null",
+			maxInput:   500,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "Request Entity Too Large\n",
+			wantMetric: metricInputTooLarge + " 1\n",
+		},
+		{
+			name:       "output larger than maxOutputBytes",
+			method:     "GET",
+			script:     "cat; sleep 10",
+			maxOutput:  500,
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal error\n",
+			wantMetric: metricOutputTooLarge + " 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fa := true
+			server := &Server{
+				Config: &scyllaridae.ServerConfig{
+					ForwardAuth:      &fa,
+					AllowedMimeTypes: []string{"*"},
+					SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+					CmdByMimeType: map[string]scyllaridae.Command{
+						"default": {
+							Cmd:            "sh",
+							Args:           []string{"-c", tt.script},
+							MaxInputBytes:  tt.maxInput,
+							MaxOutputBytes: tt.maxOutput,
+						},
+					},
+				},
+			}
+			router := server.SetupRouter()
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.method == "GET" {
+				req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+			} else {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, tt.wantBody, rr.Body.String())
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+			if tt.wantMetric != "" {
+				assert.Contains(t, rr.Body.String(), tt.wantMetric)
+			}
+		})
+	}
+}
+
+func TestMessageHandler_OutputLimitAfterStreaming(t *testing.T) {
+	const size = 3 * 1024 * 1024
+	fa := true
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd:            "head",
+					Args:           []string{"-c", fmt.Sprint(2 * size), "/dev/zero"},
+					MaxOutputBytes: size,
+				},
+			},
+		},
+	}
+	ts := httptest.NewServer(server.SetupRouter())
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader("source"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// the output has already started streaming, so the response is cut off
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.Error(t, err)
+	assert.LessOrEqual(t, len(body), size)
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package server
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup starts cmd in a process group of its own.
+func newProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills cmd along with anything else in its process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
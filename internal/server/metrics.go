@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics is a minimal registry of counters and gauges, served in the Prometheus text format.
+type Metrics struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value atomic.Int64
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ m *metric }
+
+// Inc adds one to the counter.
+func (c Counter) Inc() { c.m.value.Add(1) }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ m *metric }
+
+// Inc adds one to the gauge.
+func (g Gauge) Inc() { g.m.value.Add(1) }
+
+// Dec subtracts one from the gauge.
+func (g Gauge) Dec() { g.m.value.Add(-1) }
+
+// NewMetrics creates an empty registry.
+func NewMetrics() *Metrics {
+	return &Metrics{metrics: map[string]*metric{}}
+}
+
+func (m *Metrics) register(name, help, kind string) *metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.metrics[name]; ok {
+		return existing
+	}
+	mt := &metric{name: name, help: help, kind: kind}
+	m.metrics[name] = mt
+
+	return mt
+}
+
+// Counter returns the counter with the given name, registering it if needed.
+func (m *Metrics) Counter(name, help string) Counter {
+	return Counter{m.register(name, help, "counter")}
+}
+
+// Gauge returns the gauge with the given name, registering it if needed.
+func (m *Metrics) Gauge(name, help string) Gauge {
+	return Gauge{m.register(name, help, "gauge")}
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, name := range names {
+		m.mu.Lock()
+		mt := m.metrics[name]
+		m.mu.Unlock()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", mt.name, mt.help, mt.name, mt.kind, mt.name, mt.value.Load())
+	}
+}
@@ -2,9 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os/exec"
 	"strings"
 	"time"
 
@@ -14,12 +16,19 @@ import (
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/lestrrat-go/jwx/v3/jwt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 type contextKey string
 
 const cmdKey contextKey = "scyllaridaeCmd"
+const commandConfigKey contextKey = "scyllaridaeCommandConfig"
 const msgKey contextKey = "scyllaridaeMsg"
+const srcKey contextKey = "scyllaridaeSrc"
+const infoKey contextKey = "scyllaridaeInfo"
+const subjectKey contextKey = "scyllaridaeSubject"
+const claimsKey contextKey = "scyllaridaeClaims"
 
 type statusRecorder struct {
 	http.ResponseWriter
@@ -31,6 +40,14 @@ func (rec *statusRecorder) WriteHeader(code int) {
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+// requestInfo holds the details CommandMiddleware adds to the request log.
+type requestInfo struct {
+	cmd     *exec.Cmd
+	message api.Payload
+	// release stops redacting the request's secrets once it's been logged
+	release func()
+}
+
 func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -39,42 +56,139 @@ func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 			statusCode:     http.StatusOK,
 		}
 
+		// the command is built further down the middleware chain, once the request is authenticated
+		// so have it filled in here to add some context to our log messages
+		// along with the timing information and response status
+		info := &requestInfo{}
+		defer func() {
+			if info.release != nil {
+				info.release()
+			}
+		}()
+		ctx := context.WithValue(r.Context(), infoKey, info)
+		next.ServeHTTP(statusWriter, r.WithContext(ctx))
+		duration := time.Since(start)
+
+		command := ""
+		if info.cmd != nil {
+			command = info.cmd.String()
+		}
+		slog.Info(r.Method,
+			"path", r.URL.Path,
+			"status", statusWriter.statusCode,
+			"duration", duration,
+			"client_ip", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+			"command", command,
+			"msgId", info.message.Object.ID,
+			"client_subject", ClientSubject(r),
+		)
+	})
+}
+
+// CommandMiddleware decodes the event, opens the source and builds the command to run,
+// adding them to the request context for MessageHandler.
+// Since building the command involves reading the source's response headers
+// we're doing the setup here, which allows us to read the streams needed to process the request only once.
+func (s *Server) CommandMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := ""
 		if *s.Config.ForwardAuth {
 			auth = r.Header.Get("Authorization")
 		}
 
-		// since building the command involves reading the request body
-		// and we want to build the command here so we can add some context to our log messages
-		// along with the timing information and response status
-		// we're doing the setup here and adding the information in the context
-		// this allows us to read streams needed to process the request only once
-		message, err := api.DecodeAlpacaMessage(r, auth)
+		// the response depends on the Accept header, whether or not the command's output is negotiated
+		w.Header().Add("Vary", "Accept")
+
+		message, err := api.ParseAlpacaMessage(r, auth)
 		if err != nil {
 			slog.Error("Error decoding alpaca message", "err", err)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
-		cmd, err := config.BuildExecCommand(message, s.Config)
+		message.Claims = JWTClaims(r)
+		// the request's secrets are redacted from everything logged while it's handled
+		release := s.Redactor.Track(s.Config.SecretValues(message)...)
+		info, _ := r.Context().Value(infoKey).(*requestInfo)
+		if info != nil {
+			info.message = message
+			info.release = release
+		} else {
+			defer release()
+		}
+		slog.Debug("Got message", "msgId", message.Object.ID, "payload.attachment", message.Attachment)
+
+		// the global limits are checked before any work is done for the request
+		if !s.checkRateLimit(w, rateLimitedClients(r, message, "", s.Config.RateLimit)) {
+			slog.Warn("Rate limit exceeded", "msgId", message.Object.ID, "client_ip", r.RemoteAddr, "subject", JWTSubject(r), "actor", message.Actor.ID)
+			return
+		}
+
+		// open the source with a single GET
+		// its response headers tell us the MIME type to build the command with
+		// and its body is streamed to the command by the handler
+		src, errCode, err := s.Config.OpenSource(r, message, auth)
+		if err != nil {
+			http.Error(w, cases.Title(language.English).String(fmt.Sprint(err)), errCode)
+			return
+		}
+		defer src.Close()
+		if err := s.Config.SetSourceMimeType(&message, src); err != nil {
+			slog.Error("Error reading source", "err", err)
+			http.Error(w, "Failed Dependency", http.StatusFailedDependency)
+			return
+		}
+		message.Attachment.Content.SourceSize = src.Size
+		slog.Debug("Got source", "msgId", message.Object.ID, "SourceMimeType", message.Attachment.Content.SourceMimeType, "size", src.Size)
+
+		// the destination MIME type is one of those the command can output
+		// the command is selected once, so the one that runs is the one its limits and outputs were checked for
+		name, command := s.Config.CommandFor(message)
+		mimeType, ok := command.NegotiateOutput(message.Attachment.Content.Accept)
+		if !ok {
+			slog.Warn("No acceptable output MIME type", "msgId", message.Object.ID, "accept", message.Attachment.Content.Accept, "outputMimeTypes", command.OutputMimeTypes)
+			http.Error(w, "Not Acceptable: the output can be one of "+strings.Join(command.OutputMimeTypes, ", "), http.StatusNotAcceptable)
+			return
+		}
+		if mimeType != "" {
+			message.Attachment.Content.DestinationMimeType = mimeType
+		}
+
+		// the source's MIME type selects the command, which may have its own rate limits
+		if !s.checkRateLimit(w, rateLimitedClients(r, message, name, command.RateLimit)) {
+			slog.Warn("Command rate limit exceeded", "msgId", message.Object.ID, "cmd", name, "client_ip", r.RemoteAddr, "subject", JWTSubject(r), "actor", message.Actor.ID)
+			return
+		}
+
+		// don't start reading a source we already know is too big
+		if command.MaxInputBytes > 0 && src.Size > command.MaxInputBytes {
+			s.Metrics.Counter(metricInputTooLarge, metricInputTooLargeHelp).Inc()
+			slog.Warn("Source exceeds maxInputBytes", "msgId", message.Object.ID, "size", src.Size, "maxInputBytes", command.MaxInputBytes)
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		cmd, err := config.BuildExecCommandFor(message, s.Config, command)
 		if err != nil {
 			slog.Error("Error building command", "err", err)
+			// tell the client which of its arguments was rejected
+			var argErr *config.ArgError
+			if errors.As(err, &argErr) {
+				http.Error(w, "Bad request: "+argErr.Error(), http.StatusBadRequest)
+				return
+			}
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
+		if info != nil {
+			info.cmd = cmd
+		}
+
 		ctx := context.WithValue(r.Context(), cmdKey, cmd)
+		ctx = context.WithValue(ctx, commandConfigKey, command)
 		ctx = context.WithValue(ctx, msgKey, message)
-		next.ServeHTTP(statusWriter, r.WithContext(ctx))
-		duration := time.Since(start)
-
-		slog.Info(r.Method,
-			"path", r.URL.Path,
-			"status", statusWriter.statusCode,
-			"duration", duration,
-			"client_ip", r.RemoteAddr,
-			"user_agent", r.UserAgent(),
-			"command", cmd.String(),
-			"msgId", message.Object.ID,
-		)
+		ctx = context.WithValue(ctx, srcKey, src)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -93,33 +207,47 @@ func (s *Server) JWTAuthMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
+		var token jwt.Token
 		if !skipJwtVerify {
 			slog.Debug("Verifying JWT")
 			tokenString := a[7:]
-			err := s.verifyJWT(tokenString)
+			var err error
+			token, err = s.verifyJWT(tokenString)
 			if err != nil {
 				slog.Error("JWT verification failed", "err", err)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+		} else if len(a) > 7 && strings.EqualFold(a[:7], "bearer ") {
+			// the claims are still available to argument templates
+			token, _ = jwt.ParseInsecure([]byte(a[7:]))
 		}
 		slog.Debug("JWT verified or skipped")
 
+		if token != nil {
+			ctx := context.WithValue(r.Context(), claimsKey, tokenClaims(token))
+			// an unverified subject could be anything, so it can't identify the client
+			if sub, ok := token.Subject(); ok && !skipJwtVerify {
+				ctx = context.WithValue(ctx, subjectKey, sub)
+			}
+			r = r.WithContext(ctx)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (s *Server) verifyJWT(tokenString string) error {
+func (s *Server) verifyJWT(tokenString string) (jwt.Token, error) {
 	keySet, err := s.fetchJWKS()
 	if err != nil {
-		return fmt.Errorf("unable to fetch JWKS: %v", err)
+		return nil, fmt.Errorf("unable to fetch JWKS: %v", err)
 	}
 
 	// islandora will only ever provide a single key to sign JWTs
 	// so just use the one key in JWKS
 	key, ok := keySet.Key(0)
 	if !ok {
-		return fmt.Errorf("no key in jwks")
+		return nil, fmt.Errorf("no key in jwks")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -138,15 +266,42 @@ func (s *Server) verifyJWT(tokenString string) error {
 		)
 	}
 	if err != nil {
-		return fmt.Errorf("unable to parse token: %v", err)
+		return nil, fmt.Errorf("unable to parse token: %v", err)
 	}
 
 	err = jwt.Validate(token)
 	if err != nil {
-		return fmt.Errorf("unable to validate token: %v", err)
+		return nil, fmt.Errorf("unable to validate token: %v", err)
+	}
+
+	return token, nil
+}
+
+// JWTSubject returns the subject of the request's verified JWT.
+// It's empty when JWT verification is disabled, since the subject can't be trusted.
+func JWTSubject(r *http.Request) string {
+	sub, _ := r.Context().Value(subjectKey).(string)
+	return sub
+}
+
+// JWTClaims returns the claims of the request's JWT, if it has one.
+// Unlike the subject, the claims are returned even when JWT verification is disabled.
+func JWTClaims(r *http.Request) map[string]any {
+	claims, _ := r.Context().Value(claimsKey).(map[string]any)
+	return claims
+}
+
+// tokenClaims returns all of the token's claims, keyed by name.
+func tokenClaims(token jwt.Token) map[string]any {
+	claims := map[string]any{}
+	for _, k := range token.Keys() {
+		var v any
+		if err := token.Get(k, &v); err == nil {
+			claims[k] = v
+		}
 	}
 
-	return nil
+	return claims
 }
 
 // fetchJWKS fetches the JSON Web Key Set (JWKS) from the given URI
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+const (
+	metricRateLimited     = "scyllaridae_requests_rate_limited_total"
+	metricRateLimitedHelp = "Number of requests rejected for exceeding a rate limit."
+
+	// sweepInterval is how often buckets that have refilled are forgotten.
+	sweepInterval = time.Minute
+)
+
+// rateLimiter tracks a token bucket for every rate limited client.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// limitedClient is a client identified by key, limited by limit.
+type limitedClient struct {
+	key   string
+	limit *scyllaridae.RateLimit
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from every client's bucket if they all have one.
+// Otherwise no tokens are taken and it returns how long until they all will.
+func (l *rateLimiter) allow(clients []limitedClient) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(clients))
+	for _, c := range clients {
+		b, ok := l.buckets[c.key]
+		if !ok {
+			b = &bucket{
+				tokens: float64(c.limit.BurstSize()),
+				last:   now,
+				rate:   c.limit.PerSecond(),
+				burst:  float64(c.limit.BurstSize()),
+			}
+			l.buckets[c.key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/b.rate*float64(time.Second)))
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return true, 0
+}
+
+// sweep forgets buckets that have refilled, since they're the same as a new bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitedClients returns the clients the request counts against for limits.
+// Clients of a command's own limits are keyed by the command, so they're counted separately.
+func rateLimitedClients(r *http.Request, message api.Payload, command string, limits *scyllaridae.RateLimitConfig) []limitedClient {
+	if limits == nil {
+		return nil
+	}
+
+	var clients []limitedClient
+	add := func(scope, key string, limit *scyllaridae.RateLimit) {
+		if limit == nil || key == "" {
+			return
+		}
+		if command != "" {
+			scope = command + "|" + scope
+		}
+		clients = append(clients, limitedClient{key: scope + "|" + key, limit: limit})
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	add("ip", ip, limits.PerClientIP)
+	add("sub", JWTSubject(r), limits.PerSubject)
+	add("actor", message.Actor.ID, limits.PerActor)
+
+	return clients
+}
+
+// checkRateLimit responds with 429 Too Many Requests if the request is over any of its clients' limits.
+func (s *Server) checkRateLimit(w http.ResponseWriter, clients []limitedClient) bool {
+	if len(clients) == 0 || s.rateLimiter == nil {
+		return true
+	}
+
+	ok, wait := s.rateLimiter.allow(clients)
+	if ok {
+		return true
+	}
+
+	s.Metrics.Counter(metricRateLimited, metricRateLimitedHelp).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+
+	return false
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	perIP := &scyllaridae.RateLimit{Requests: 1, Per: 10 * time.Second, Burst: 2}
+	perActor := &scyllaridae.RateLimit{Requests: 1, Per: time.Minute}
+	ip := limitedClient{key: "ip|10.0.0.1", limit: perIP}
+	actor := limitedClient{key: "actor|1", limit: perActor}
+
+	// the burst is allowed straight away
+	ok, _ := l.allow([]limitedClient{ip})
+	assert.True(t, ok)
+	ok, _ = l.allow([]limitedClient{ip})
+	assert.True(t, ok)
+	ok, wait := l.allow([]limitedClient{ip})
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	// tokens refill over time
+	now = now.Add(5 * time.Second)
+	ok, wait = l.allow([]limitedClient{ip})
+	assert.False(t, ok)
+	assert.Equal(t, 5*time.Second, wait)
+	now = now.Add(5 * time.Second)
+	ok, _ = l.allow([]limitedClient{ip, actor})
+	assert.True(t, ok)
+
+	// a request over any limit doesn't use up the others
+	now = now.Add(10 * time.Second)
+	ok, wait = l.allow([]limitedClient{ip, actor})
+	assert.False(t, ok)
+	assert.Equal(t, 50*time.Second, wait)
+	ok, _ = l.allow([]limitedClient{ip})
+	assert.True(t, ok)
+
+	// refilled buckets are forgotten
+	now = now.Add(time.Hour)
+	l.allow(nil)
+	assert.Empty(t, l.buckets)
+}
+
+func TestCommandMiddleware_RateLimit(t *testing.T) {
+	var fetches atomic.Int32
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	fa := true
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+			RateLimit: &scyllaridae.RateLimitConfig{
+				PerClientIP: &scyllaridae.RateLimit{Requests: 3, Per: time.Minute},
+				PerActor:    &scyllaridae.RateLimit{Requests: 2, Per: time.Minute},
+			},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {Cmd: "cat"},
+				"image/tiff": {
+					Cmd: "cat",
+					RateLimit: &scyllaridae.RateLimitConfig{
+						PerActor: &scyllaridae.RateLimit{Requests: 1, Per: time.Hour},
+					},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	request := func(remoteAddr, actor, mimeType string) *httptest.ResponseRecorder {
+		message := api.Payload{}
+		message.Actor.ID = actor
+		message.Attachment.Content.SourceURI = mockSource.URL + "?type=" + mimeType
+		b, err := json.Marshal(message)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Islandora-Event", base64.StdEncoding.EncodeToString(b))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// per actor
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1234", "1", "text/plain").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1234", "1", "text/plain").Code)
+	rr := request("10.0.0.3:1234", "1", "text/plain")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	// the global limits are checked before the source is fetched
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// the command's own actor limit is counted separately, as well as the global one
+	assert.Equal(t, http.StatusOK, request("10.0.0.4:1234", "6", "image/tiff").Code)
+	rr = request("10.0.0.5:1234", "6", "image/tiff")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "3600", rr.Header().Get("Retry-After"))
+
+	// per client IP
+	assert.Equal(t, http.StatusOK, request("10.0.0.6:1234", "2", "text/plain").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.6:1234", "3", "text/plain").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.6:1234", "4", "text/plain").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.6:1234", "5", "text/plain").Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), metricRateLimited+" 3\n")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogging_RedactsSecrets(t *testing.T) {
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	fa := true
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+			Redact: &scyllaridae.RedactConfig{
+				Patterns:     []string{`password=\S+`},
+				Placeholders: []string{"%target"},
+			},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd: "sh",
+					Args: []string{"-c", `echo "auth $SCYLLARIDAE_AUTH" >&2; echo "uri $1 password=hunter2" >&2; exit 1`,
+						"sh", "%source-uri", "%target"},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(server.Redactor.Handler(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	defer slog.SetDefault(defaultLogger)
+
+	secrets := []string{
+		"opaque-auth-token",
+		"sourcetoken",
+		"sig1234",
+		"hunter2",
+		"private-target",
+	}
+	for _, method := range []string{"GET", "POST"} {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Authorization", "Bearer opaque-auth-token")
+		req.Header.Set("Apix-Ldp-Resource", mockSource.URL+"/file.txt?token=sourcetoken&X-Amz-Signature=sig1234")
+		req.Header.Set("X-Islandora-Event", "eyJ0YXJnZXQiOiJwcml2YXRlLXRhcmdldCJ9")
+		req.Header.Set("Content-Type", "text/plain")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	}
+
+	out := logs.String()
+	assert.Contains(t, out, "Error running command")
+	assert.Contains(t, out, "[REDACTED]")
+	for _, secret := range secrets {
+		assert.NotContains(t, out, secret)
+	}
+
+	// secrets are only redacted while their request is in progress
+	assert.Equal(t, "opaque-auth-token private-target", server.Redactor.String("opaque-auth-token private-target"))
+}
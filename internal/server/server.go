@@ -2,31 +2,52 @@ package server
 
 import (
 	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 
 	lru "github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/islandora/scyllaridae/internal/cache"
 	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/internal/redact"
+	"github.com/islandora/scyllaridae/internal/sandbox"
 	"github.com/islandora/scyllaridae/pkg/api"
 	"github.com/lestrrat-go/jwx/v3/jwk"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 type Server struct {
 	Config  *scyllaridae.ServerConfig
 	KeySets *lru.LRU[string, jwk.Set]
+	// Cache stores command output when caching is configured.
+	Cache *cache.Cache
+	// Metrics are served at /metrics.
+	Metrics *Metrics
+	// Redactor removes secrets from logs.
+	// It only applies to logs written with its Handler.
+	Redactor *redact.Redactor
+
+	// path is the prefix a service is served at, "" for the top-level service
+	path        string
+	flights     *flightGroup
+	rateLimiter *rateLimiter
 }
 
 // RunHTTPServer starts the HTTP server and listens on the configured port.
 // The port is determined by the SCYLLARIDAE_PORT environment variable, defaulting to 8080.
+// When tls is configured the server serves HTTPS instead of plaintext HTTP.
 // This function blocks and will panic if the server fails to start.
 func RunHTTPServer(server *Server) {
 	r := server.SetupRouter()
@@ -36,38 +57,127 @@ func RunHTTPServer(server *Server) {
 		port = "8080"
 	}
 
-	slog.Info("Server listening", "port", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	if server.Config.TLS == nil {
+		slog.Info("Server listening", "port", port)
+		if err := srv.ListenAndServe(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	tc, err := BuildTLSConfig(server.Config.TLS)
+	if err != nil {
+		panic(err)
+	}
+	srv.TLSConfig = tc
+
+	slog.Info("Server listening with TLS", "port", port, "clientAuth", tc.ClientAuth.String())
+	// the certificate is served by tls.Config.GetCertificate
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
 		panic(err)
 	}
 }
 
 func (server *Server) SetupRouter() *mux.Router {
-	if server.Config.JwksUri == "" {
+	if server.Config.JwksUri == "" && server.Config.Serves() {
 		slog.Info("No JWKS URI configured, skipping JWT verification")
 	}
 
 	server.KeySets = lru.NewLRU[string, jwk.Set](25, nil, time.Minute*15)
+	server.flights = newFlightGroup()
+	if server.Redactor == nil {
+		server.Redactor = redact.New(server.Config.Redact)
+	}
+	server.rateLimiter = newRateLimiter()
+	if server.Metrics == nil {
+		server.Metrics = NewMetrics()
+	}
+	server.Metrics.Counter(metricCommands, metricCommandsHelp)
+	server.Metrics.Counter(metricCoalesced, metricCoalescedHelp)
+	server.Metrics.Counter(metricRateLimited, metricRateLimitedHelp)
+	server.Metrics.Counter(metricInputTooLarge, metricInputTooLargeHelp)
+	server.Metrics.Counter(metricOutputTooLarge, metricOutputTooLargeHelp)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "OK")
 	}).Methods("GET")
+	r.Handle("/metrics", server.Metrics).Methods("GET")
+
+	// services are mounted first so the top-level service's routes don't match their paths
+	for _, svc := range server.services() {
+		svc.mount(r, svc.path)
+	}
+	if server.Config.Serves() {
+		server.mount(r, "")
+	} else {
+		r.NotFoundHandler = server.LoggingMiddleware(http.HandlerFunc(notFound))
+	}
+
+	return r
+}
+
+// services returns a Server for each of the config's services,
+// sharing the server's JWKS cache, output cache, metrics and redactor.
+// Longer paths come first, so a service isn't hidden by one mounted at a parent path.
+func (server *Server) services() []*Server {
+	services := []*Server{}
+	for name, svc := range server.Config.Services {
+		if svc.JwksUri == "" {
+			slog.Info("No JWKS URI configured, skipping JWT verification", "service", name)
+		}
+		services = append(services, &Server{
+			Config:      &svc.ServerConfig,
+			KeySets:     server.KeySets,
+			Cache:       server.Cache,
+			Metrics:     server.Metrics,
+			Redactor:    server.Redactor,
+			path:        svc.Path,
+			flights:     newFlightGroup(),
+			rateLimiter: newRateLimiter(),
+		})
+	}
+	slices.SortFunc(services, func(a, b *Server) int {
+		return cmp.Or(len(b.path)-len(a.path), strings.Compare(a.path, b.path))
+	})
+
+	return services
+}
+
+// mount adds the routes to process files and purge the cache under prefix, "" for the top-level service.
+func (server *Server) mount(r *mux.Router, prefix string) {
+	// purging the cache needs the same authentication as processing files
+	cacheRouter := r.PathPrefix(prefix + "/cache").Subrouter()
+	cacheRouter.Use(server.ClientCertMiddleware, server.LoggingMiddleware, server.JWTAuthMiddleware)
+	cacheRouter.HandleFunc("", server.PurgeCacheHandler).Methods("DELETE")
 
 	// create the main route with logging and JWT auth middleware
-	authRouter := r.PathPrefix("/").Subrouter()
-	authRouter.Use(server.LoggingMiddleware, server.JWTAuthMiddleware)
+	// the source is only fetched and the command built once the request is authenticated
+	authRouter := r.PathPrefix(prefix + "/").Subrouter()
+	authRouter.Use(server.ClientCertMiddleware, server.LoggingMiddleware, server.JWTAuthMiddleware, server.CommandMiddleware)
 	authRouter.HandleFunc("/", server.MessageHandler).Methods("GET", "POST")
+	if prefix != "" {
+		// a service is also served at its path without the trailing slash
+		var handler http.Handler = http.HandlerFunc(server.MessageHandler)
+		for _, mw := range []mux.MiddlewareFunc{server.CommandMiddleware, server.JWTAuthMiddleware, server.LoggingMiddleware, server.ClientCertMiddleware} {
+			handler = mw(handler)
+		}
+		r.Handle(prefix, handler).Methods("GET", "POST")
+	}
 
 	// make sure 404s get logged
-	notFoundHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Connection", "close")
-		http.Error(w, "404 Not Found", http.StatusNotFound)
-	})
-	authRouter.NotFoundHandler = server.LoggingMiddleware(notFoundHandler)
+	authRouter.NotFoundHandler = server.LoggingMiddleware(http.HandlerFunc(notFound))
+}
 
-	return r
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, "404 Not Found", http.StatusNotFound)
 }
 
 // bufferingWriter buffers initial output to detect early command failures.
@@ -111,6 +221,27 @@ func (bw *bufferingWriter) Write(p []byte) (int, error) {
 	return bw.w.Write(p)
 }
 
+// finish sends the rest of the output once the command has succeeded.
+// Output that fit in the buffer is all known before it's sent,
+// so it's sent with its Content-Length and digest.
+func (bw *bufferingWriter) finish() error {
+	if !bw.flushed {
+		setContentDigest(bw.w.Header(), bw.buffer.Bytes())
+	}
+
+	return bw.flush()
+}
+
+// setContentDigest sets the Content-Length of body and its SHA-256 digest,
+// as both a Content-Digest (RFC 9530) and legacy Digest (RFC 3230) header.
+func setContentDigest(h http.Header, body []byte) {
+	sum := sha256.Sum256(body)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Set("Content-Digest", "sha-256=:"+digest+":")
+	h.Set("Digest", "SHA-256="+digest)
+}
+
 func (bw *bufferingWriter) flush() error {
 	if bw.flushed {
 		return nil
@@ -123,6 +254,25 @@ func (bw *bufferingWriter) flush() error {
 	return nil
 }
 
+// clientWriter writes the output to the client, recording the first error instead of returning it.
+// Later writes are dropped, so a client disconnecting doesn't stop the command's output
+// reaching the cache and any coalesced requests.
+type clientWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *clientWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return len(p), nil
+	}
+	if _, err := c.w.Write(p); err != nil {
+		c.err = err
+	}
+
+	return len(p), nil
+}
+
 func (s *Server) MessageHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -130,28 +280,48 @@ func (s *Server) MessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	auth := ""
-	if *s.Config.ForwardAuth {
-		auth = r.Header.Get("Authorization")
-	}
 	cmd := r.Context().Value(cmdKey).(*exec.Cmd)
 	message := r.Context().Value(msgKey).(api.Payload)
+	src := r.Context().Value(srcKey).(*scyllaridae.Source)
+	command := r.Context().Value(commandConfigKey).(scyllaridae.Command)
 
-	// Stream the file contents from the source URL or request body
-	fs, errCode, err := s.Config.GetFileStream(r, message, auth)
-	if err != nil {
-		http.Error(w, cases.Title(language.English).String(fmt.Sprint(err)), errCode)
-		return
-	}
-	if fs != nil {
-		defer fs.Close()
-		cmd.Stdin = fs
+	// the source was opened by CommandMiddleware, which closes it when we're done
+	if src.Body != nil {
+		cmd.Stdin = src.Body
 	}
 
 	// Create a buffer to capture stderr
 	var stdErr bytes.Buffer
 	cmd.Stderr = &stdErr
 
+	// write the derivative straight to the Drupal files directory if configured
+	derivative, err := s.Config.CreateDerivative(message)
+	if err != nil {
+		slog.Error("Error creating derivative file", "uri", message.Attachment.Content.FileUploadURI, "err", err)
+		if errors.Is(err, scyllaridae.ErrInvalidSource) {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, scyllaridae.ErrDerivativeExists) {
+			http.Error(w, "Conflict: file_upload_uri already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if derivative != nil {
+		s.writeDerivative(w, cmd, command, &stdErr, derivative)
+		return
+	}
+
+	contentType := outputContentType(message, command)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if disposition := command.ContentDispositionFor(message, contentType); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+
 	// Use buffering writer to detect early failures (buffer first 2MB)
 	const bufferSize = 2 * 1024 * 1024 // 2MB
 	bw := &bufferingWriter{
@@ -160,26 +330,166 @@ func (s *Server) MessageHandler(w http.ResponseWriter, r *http.Request) {
 		maxBuffer: bufferSize,
 		flushed:   false,
 	}
-	cmd.Stdout = bw
+	// the output is also sent to the cache and any coalesced requests
+	var outputs []io.Writer
 
-	err = cmd.Run()
+	if s.Cache != nil {
+		key := cacheKey(s.path, cmd, command, message, src)
+		if key == "" {
+			w.Header().Set(cacheStatusHeader, "BYPASS")
+		} else if s.serveCached(w, key) {
+			return
+		} else {
+			w.Header().Set(cacheStatusHeader, "MISS")
+		}
+	}
+
+	// identical requests already running share that command's output instead of running it again
+	sharedKey := flightKey(cmd, command, message, src)
+	var leading *flight
+	if s.flights != nil && sharedKey != "" && s.Config.CoalesceRequests() {
+		f, leader, err := s.flights.join(sharedKey)
+		if err != nil {
+			slog.Error("Unable to coalesce request", "err", err)
+		} else {
+			defer f.release()
+			if !leader {
+				// the source isn't needed since the command is already running
+				src.Close()
+				s.Metrics.Counter(metricCoalesced, metricCoalescedHelp).Inc()
+				slog.Debug("Coalescing request with running command", "msgId", message.Object.ID, "cmd", cmd.String())
+				s.followFlight(w, bw, f)
+				return
+			}
+			leading = f
+			outputs = append(outputs, f)
+		}
+	}
+
+	var cached *cache.Writer
+	if key := cacheKey(s.path, cmd, command, message, src); s.Cache != nil && key != "" {
+		cached, err = s.Cache.Create(key, s.path, message.Attachment.Content.SourceURI)
+		if err != nil {
+			slog.Error("Unable to cache output", "err", err)
+		} else {
+			outputs = append(outputs, cached)
+		}
+	}
+	// with nothing else reading the output, a client disconnecting stops the command
+	client := &clientWriter{w: bw}
+	if len(outputs) == 0 {
+		cmd.Stdout = bw
+	} else {
+		cmd.Stdout = io.MultiWriter(append(outputs, client)...)
+	}
+
+	s.Metrics.Counter(metricCommands, metricCommandsHelp).Inc()
+	err = s.runCommand(cmd, command)
+	if leading != nil {
+		s.flights.land(sharedKey, leading, err)
+	}
+	if err != nil && cached != nil {
+		cached.Abort()
+	}
 	if err != nil {
-		slog.Error("Error running command", "cmd", cmd.String(), "cmdStdErr", stdErr.String())
+		slog.Error("Error running command", "cmd", cmd.String(), "cmdStdErr", stdErr.String(), "err", err)
 		// If buffer hasn't been flushed yet, we can still send an error response
 		if !bw.flushed {
+			if errors.Is(err, errInputTooLarge) {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
+		// the output was cut short, so don't let the client think it got all of it
+		if errors.Is(err, errInputTooLarge) || errors.Is(err, errOutputTooLarge) {
+			panic(http.ErrAbortHandler)
+		}
 		// Headers already sent - partial output delivered with 200 status
 		// Log the error but can't change response status
 		slog.Warn("Command failed after streaming started", "cmd", cmd.String(), "bytesWritten", bw.totalWrites)
 		return
 	}
 
+	if cached != nil {
+		if err := cached.Commit(); err != nil {
+			slog.Error("Unable to cache output", "err", err)
+		}
+	}
+
+	if client.err != nil {
+		slog.Warn("Client disconnected before the output was sent", "cmd", cmd.String(), "err", client.err)
+		return
+	}
+
 	// Command succeeded - flush any remaining buffered data
-	if err := bw.flush(); err != nil {
+	if err := bw.finish(); err != nil {
 		slog.Error("Error flushing output", "err", err)
 		return
 	}
 	slog.Debug("Command completed", "msgId", message.Object.ID, "cmd", cmd.String(), "cmdStdErr", stdErr.String())
 }
+
+// outputContentType returns the Content-Type of the command's output:
+// the destination MIME type when it was negotiated or asked for by the Accept header,
+// otherwise "" for it to be detected from the output.
+func outputContentType(message api.Payload, command scyllaridae.Command) string {
+	if len(command.OutputMimeTypes) > 0 || api.PreferredMimeType(message.Attachment.Content.Accept) != "" {
+		return message.Attachment.Content.DestinationMimeType
+	}
+
+	return ""
+}
+
+// writeDerivative runs the command with its output written to the derivative on disk,
+// responding with the derivative's location once it's in place.
+func (s *Server) writeDerivative(w http.ResponseWriter, cmd *exec.Cmd, command scyllaridae.Command, stdErr *bytes.Buffer, derivative *scyllaridae.Derivative) {
+	cmd.Stdout = derivative
+	if err := s.runCommand(cmd, command); err != nil {
+		slog.Error("Error running command", "cmd", cmd.String(), "cmdStdErr", stdErr.String(), "err", err)
+		if err := derivative.Abort(); err != nil {
+			slog.Error("Error removing partial derivative", "uri", derivative.URI, "err", err)
+		}
+		if errors.Is(err, errInputTooLarge) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := derivative.Commit(); err != nil {
+		slog.Error("Error saving derivative", "uri", derivative.URI, "err", err)
+		if errors.Is(err, scyllaridae.ErrDerivativeExists) {
+			http.Error(w, "Conflict: file_upload_uri already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Debug("Derivative written to disk", "cmd", cmd.String(), "uri", derivative.URI, "cmdStdErr", stdErr.String())
+	w.Header().Set("Location", derivative.URI)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// runCommand runs cmd within the command's size limits and sandbox.
+func (s *Server) runCommand(cmd *exec.Cmd, command scyllaridae.Command) error {
+	overLimit := limitStreams(cmd, command.MaxInputBytes, command.MaxOutputBytes)
+	if command.Sandbox != nil {
+		cleanup, err := sandbox.Wrap(cmd, command.Sandbox)
+		if err != nil {
+			return fmt.Errorf("unable to sandbox command: %w", err)
+		}
+		defer cleanup()
+	}
+
+	err := cmd.Run()
+	if limitErr := overLimit(); limitErr != nil {
+		s.countOverLimit(limitErr)
+		return limitErr
+	}
+
+	return err
+}
@@ -12,14 +12,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type JWK struct {
@@ -36,6 +39,13 @@ type JWKS struct {
 	Keys []JWK `json:"keys"`
 }
 
+// the mock servers all listen on loopback, which the source policy denies by default
+const loopbackSourcePolicy = `
+sourcePolicy:
+  allowedCidrs:
+    - "127.0.0.0/8"
+`
+
 type Test struct {
 	name                string
 	authHeader          string
@@ -74,6 +84,7 @@ func TestBufferingWriter_EarlyFailure(t *testing.T) {
 	testConfig := &scyllaridae.ServerConfig{
 		ForwardAuth:      &fa,
 		AllowedMimeTypes: []string{"*"},
+		SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
 		CmdByMimeType: map[string]scyllaridae.Command{
 			"default": {
 				// Command that fails immediately without output
@@ -110,6 +121,7 @@ func TestBufferingWriter_SmallOutput(t *testing.T) {
 	testConfig := &scyllaridae.ServerConfig{
 		ForwardAuth:      &fa,
 		AllowedMimeTypes: []string{"*"},
+		SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
 		CmdByMimeType: map[string]scyllaridae.Command{
 			"default": {
 				Cmd:  "echo",
@@ -138,6 +150,149 @@ func TestBufferingWriter_SmallOutput(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "small output")
 }
 
+func TestSourcePolicy_DeniedSource(t *testing.T) {
+	fa := true
+	testConfig := &scyllaridae.ServerConfig{
+		ForwardAuth:      &fa,
+		AllowedMimeTypes: []string{"*"},
+		CmdByMimeType: map[string]scyllaridae.Command{
+			"default": {
+				Cmd: "cat",
+			},
+		},
+	}
+	server := &Server{Config: testConfig}
+
+	requested := false
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockSource.Close()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rr := httptest.NewRecorder()
+	router := server.SetupRouter()
+	router.ServeHTTP(rr, req)
+
+	// loopback sources are denied unless explicitly allowed
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.False(t, requested, "source should never be contacted")
+}
+
+func TestMessageHandler_SourceFetchedOnce(t *testing.T) {
+	fa := true
+	testConfig := &scyllaridae.ServerConfig{
+		ForwardAuth:      &fa,
+		JwksUri:          "http://127.0.0.1:1/keys",
+		AllowedMimeTypes: []string{"text/plain"},
+		SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+		CmdByMimeType: map[string]scyllaridae.Command{
+			"default": {
+				Cmd: "cat",
+			},
+		},
+	}
+	server := &Server{Config: testConfig}
+
+	var methods []string
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	// unauthenticated requests never reach the source
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+	rr := httptest.NewRecorder()
+	router := server.SetupRouter()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Empty(t, methods)
+
+	// once authenticated the source is fetched with a single GET
+	testConfig.JwksUri = ""
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+	rr = httptest.NewRecorder()
+	router = server.SetupRouter()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "source", rr.Body.String())
+	assert.Equal(t, []string{"GET"}, methods)
+}
+
+func TestMessageHandler_WriteDerivative(t *testing.T) {
+	private := t.TempDir()
+	fa := true
+	testConfig := &scyllaridae.ServerConfig{
+		ForwardAuth:      &fa,
+		AllowedMimeTypes: []string{"*"},
+		SourcePolicy:     &scyllaridae.SourcePolicy{AllowedSchemes: []string{"private"}},
+		StreamWrappers: map[string]scyllaridae.StreamWrapper{
+			"private": {Root: private, WriteDerivatives: true},
+		},
+		CmdByMimeType: map[string]scyllaridae.Command{
+			"default": {
+				Cmd: "cat",
+			},
+		},
+	}
+	server := &Server{Config: testConfig}
+	require.NoError(t, os.WriteFile(filepath.Join(private, "source.txt"), []byte("source"), 0600))
+
+	event := func(source, upload string) string {
+		message := api.Payload{}
+		message.Attachment.Content.SourceURI = source
+		message.Attachment.Content.FileUploadURI = upload
+		b, err := json.Marshal(message)
+		require.NoError(t, err)
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Islandora-Event", event("private://source.txt", "private://derivatives/copy.txt"))
+	rr := httptest.NewRecorder()
+	server.SetupRouter().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "private://derivatives/copy.txt", rr.Header().Get("Location"))
+	assert.Empty(t, rr.Body.String())
+	b, err := os.ReadFile(filepath.Join(private, "derivatives", "copy.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(b))
+
+	// a failed command leaves nothing behind
+	testConfig.CmdByMimeType["default"] = scyllaridae.Command{Cmd: "false"}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Islandora-Event", event("private://source.txt", "private://derivatives/failed.txt"))
+	rr = httptest.NewRecorder()
+	server.SetupRouter().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	entries, err := os.ReadDir(filepath.Join(private, "derivatives"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// existing files, like the source, are never written over
+	testConfig.CmdByMimeType["default"] = scyllaridae.Command{Cmd: "cat"}
+	for _, upload := range []string{"private://source.txt", "private://derivatives/copy.txt"} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Islandora-Event", event("private://source.txt", upload))
+		rr = httptest.NewRecorder()
+		server.SetupRouter().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code, upload)
+	}
+	b, err = os.ReadFile(filepath.Join(private, "source.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(b))
+}
+
 func TestIntegration(t *testing.T) {
 	tests := []Test{
 		{
@@ -313,7 +468,7 @@ cmdByMimeType:
 			destinationServer := createMockDestinationServer(t, tt.returnedBody)
 			defer destinationServer.Close()
 
-			os.Setenv("SCYLLARIDAE_YML", tt.yml)
+			os.Setenv("SCYLLARIDAE_YML", tt.yml+loopbackSourcePolicy)
 			config, err := scyllaridae.ReadConfig()
 
 			sourceServer := createMockSourceServer(t, config, tt.mimetype, tt.authHeader, destinationServer.URL)
@@ -567,7 +722,7 @@ cmdByMimeType:
 			defer destinationServer.Close()
 
 			// Update YAML to include JWKS URI
-			ymlWithJwks := tt.yml + "\njwksUri: " + jwksServer.URL
+			ymlWithJwks := tt.yml + loopbackSourcePolicy + "\njwksUri: " + jwksServer.URL
 			os.Setenv("SCYLLARIDAE_YML", ymlWithJwks)
 			config, err := scyllaridae.ReadConfig()
 
@@ -736,6 +891,7 @@ cmdByMimeType:
     args:
       - "%args"
 `
+			yml += loopbackSourcePolicy
 			if tt.jwksUri != "" {
 				yml += "jwksUri: " + tt.jwksUri
 			}
@@ -796,3 +952,237 @@ cmdByMimeType:
 		})
 	}
 }
+
+func TestCommandMiddleware_JWTClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token, err := jwt.NewBuilder().
+		Subject("1234567890").
+		Claim("name", "test-user").
+		Build()
+	require.NoError(t, err)
+	signedToken, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), key))
+	require.NoError(t, err)
+
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd:          "echo",
+					Args:         []string{"{{.Claims.sub}}", "{{.Claims.name}}"},
+					ArgsTemplate: true,
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer "+string(signedToken))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1234567890 test-user\n", rr.Body.String())
+
+	// the unverified subject isn't used to identify the client
+	var subject string
+	server.JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = JWTSubject(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, subject)
+
+	// a template referencing a claim the request doesn't have is a bad request
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestCommandMiddleware_ArgPolicyAndPresets(t *testing.T) {
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd:  "echo",
+					Args: []string{"%args"},
+					ArgPolicy: &scyllaridae.ArgPolicy{
+						AllowedFlags:   map[string]string{"-quality": "[0-9]+"},
+						ForbiddenFlags: []string{"-write"},
+					},
+					Presets: map[string][]string{"thumbnail": {"-thumbnail", "100x100"}},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	tests := []struct {
+		args         string
+		preset       string
+		expectedCode int
+		expectedBody string
+	}{
+		{args: "-quality 80", expectedCode: http.StatusOK, expectedBody: "-quality 80\n"},
+		{args: "preset:thumbnail", expectedCode: http.StatusOK, expectedBody: "-thumbnail 100x100\n"},
+		{args: "-quality 80", preset: "thumbnail", expectedCode: http.StatusOK, expectedBody: "-thumbnail 100x100 -quality 80\n"},
+		{preset: "ocr-eng", expectedCode: http.StatusBadRequest, expectedBody: "Bad request: argument \"ocr-eng\" is not a preset for this command\n"},
+		{args: "-quality 80 -write out.png", expectedCode: http.StatusBadRequest, expectedBody: "Bad request: argument \"-write\" is forbidden\n"},
+		{args: "-quality high", expectedCode: http.StatusBadRequest, expectedBody: "Bad request: argument \"high\" is not an allowed value for -quality\n"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Islandora-Args", tt.args)
+		req.Header.Set("X-Islandora-Preset", tt.preset)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, tt.expectedCode, rr.Code, tt.args)
+		assert.Equal(t, tt.expectedBody, rr.Body.String(), tt.args)
+	}
+}
+
+func TestCommandMiddleware_NegotiatesOutput(t *testing.T) {
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"application/pdf": {
+					Cmd:             "echo",
+					Args:            []string{"%destination-mime-ext"},
+					OutputMimeTypes: []string{"image/png", "image/webp"},
+				},
+				"default": {
+					Cmd:  "echo",
+					Args: []string{"hello"},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	tests := []struct {
+		name                string
+		sourceMimeType      string
+		accept              string
+		expectedCode        int
+		expectedBody        string
+		expectedContentType string
+	}{
+		{name: "browser style Accept", sourceMimeType: "application/pdf", accept: "image/jpeg, image/webp;q=0.8, */*;q=0.1", expectedCode: http.StatusOK, expectedBody: "webp\n", expectedContentType: "image/webp"},
+		{name: "no Accept", sourceMimeType: "application/pdf", expectedCode: http.StatusOK, expectedBody: "png\n", expectedContentType: "image/png"},
+		{name: "not acceptable", sourceMimeType: "application/pdf", accept: "application/pdf", expectedCode: http.StatusNotAcceptable, expectedBody: "Not Acceptable: the output can be one of image/png, image/webp\n", expectedContentType: "text/plain; charset=utf-8"},
+		{name: "asked for without negotiation", sourceMimeType: "text/plain", accept: "text/html;q=0.9, application/pdf", expectedCode: http.StatusOK, expectedBody: "hello\n", expectedContentType: "application/pdf"},
+		{name: "detected without negotiation", sourceMimeType: "text/plain", accept: "*/*", expectedCode: http.StatusOK, expectedBody: "hello\n", expectedContentType: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Content-Type", tt.sourceMimeType)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+			assert.Equal(t, tt.expectedContentType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, []string{"Accept"}, rr.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestCommandMiddleware_NegotiatedCommandRuns(t *testing.T) {
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			// the route would match once the destination MIME type is negotiated
+			Routes: []scyllaridae.Route{
+				{DestinationMimeTypes: []string{"image/png"}, Command: "png"},
+			},
+			Commands: map[string]scyllaridae.Command{
+				"png": {Cmd: "echo", Args: []string{"routed"}},
+			},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd:             "echo",
+					Args:            []string{"%destination-mime-ext"},
+					OutputMimeTypes: []string{"image/png", "image/webp"},
+				},
+			},
+		},
+	}
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Content-Type", "application/pdf")
+	req.Header.Set("Accept", "image/*")
+	rr := httptest.NewRecorder()
+	server.SetupRouter().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "png\n", rr.Body.String())
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+}
+
+func TestMessageHandler_ContentHeaders(t *testing.T) {
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"application/pdf": {
+					Cmd:                "echo",
+					Args:               []string{"-n", "hello"},
+					OutputMimeTypes:    []string{"image/webp"},
+					ContentDisposition: "attachment",
+				},
+				"default": {
+					// more output than is buffered, so it's streamed
+					Cmd:  "head",
+					Args: []string{"-c", "3000000", "/dev/zero"},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	t.Run("buffered output", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", "application/pdf")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "hello", rr.Body.String())
+		assert.Equal(t, "image/webp", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=output.webp", rr.Header().Get("Content-Disposition"))
+		assert.Equal(t, "5", rr.Header().Get("Content-Length"))
+		// echo -n hello | sha256sum | xxd -r -p | base64
+		assert.Equal(t, "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:", rr.Header().Get("Content-Digest"))
+		assert.Equal(t, "SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=", rr.Header().Get("Digest"))
+	})
+
+	t.Run("streamed output", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", "text/plain")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, 3000000, rr.Body.Len())
+		assert.Empty(t, rr.Header().Get("Content-Disposition"))
+		assert.Empty(t, rr.Header().Get("Content-Length"))
+		assert.Empty(t, rr.Header().Get("Content-Digest"))
+		assert.Empty(t, rr.Header().Get("Digest"))
+	})
+}
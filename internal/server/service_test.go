@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupRouter_Services(t *testing.T) {
+	fa := false
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			Services: map[string]*scyllaridae.ServiceConfig{
+				"houdini": {
+					Path: "/houdini",
+					ServerConfig: scyllaridae.ServerConfig{
+						ForwardAuth:      &fa,
+						AllowedMimeTypes: []string{"image/*"},
+						CmdByMimeType: map[string]scyllaridae.Command{
+							"default": {Cmd: "echo", Args: []string{"houdini"}},
+						},
+					},
+				},
+				"homarus": {
+					Path: "/homarus",
+					ServerConfig: scyllaridae.ServerConfig{
+						ForwardAuth:      &fa,
+						AllowedMimeTypes: []string{"*"},
+						// the JWKS URI is never fetched, requests without a token are rejected first
+						JwksUri: "http://127.0.0.1:1/keys",
+						CmdByMimeType: map[string]scyllaridae.Command{
+							"default": {Cmd: "echo", Args: []string{"homarus"}},
+						},
+					},
+				},
+				"homarus-audio": {
+					Path: "/homarus/audio",
+					ServerConfig: scyllaridae.ServerConfig{
+						ForwardAuth:      &fa,
+						AllowedMimeTypes: []string{"*"},
+						CmdByMimeType: map[string]scyllaridae.Command{
+							"default": {Cmd: "echo", Args: []string{"homarus-audio"}},
+						},
+					},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+
+	request := func(method, path, mimeType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("source"))
+		req.Header.Set("Content-Type", mimeType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		mimeType     string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "service", path: "/houdini/", mimeType: "image/png", expectedCode: http.StatusOK, expectedBody: "houdini\n"},
+		{name: "without trailing slash", path: "/houdini", mimeType: "image/png", expectedCode: http.StatusOK, expectedBody: "houdini\n"},
+		{name: "service's own MIME types", path: "/houdini/", mimeType: "video/mp4", expectedCode: http.StatusBadRequest},
+		{name: "service's own auth", path: "/homarus/", mimeType: "video/mp4", expectedCode: http.StatusBadRequest, expectedBody: "Missing Authorization header\n"},
+		{name: "nested service", path: "/homarus/audio/", mimeType: "audio/mpeg", expectedCode: http.StatusOK, expectedBody: "homarus-audio\n"},
+		{name: "no top-level service", path: "/", mimeType: "image/png", expectedCode: http.StatusNotFound},
+		{name: "unknown service", path: "/hypercube/", mimeType: "image/png", expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := request("POST", tt.path, tt.mimeType)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+
+	// the services share the server's metrics
+	rr := request("GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), metricCommands+" 3\n")
+}
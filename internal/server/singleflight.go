@@ -0,0 +1,188 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
+)
+
+const (
+	metricCommands      = "scyllaridae_commands_total"
+	metricCommandsHelp  = "Number of commands run."
+	metricCoalesced     = "scyllaridae_requests_coalesced_total"
+	metricCoalescedHelp = "Number of requests served with the output of an identical request's command."
+)
+
+// flightGroup tracks the commands currently running so identical requests can share their output.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[string]*flight{}}
+}
+
+// join returns the in-flight command for key, or starts a new one if there is none.
+// leader is true if the caller started the flight and has to run the command.
+// The caller must release the flight once it's done with it.
+func (g *flightGroup) join(key string) (f *flight, leader bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		f.mu.Lock()
+		f.refs++
+		f.mu.Unlock()
+		return f, false, nil
+	}
+
+	// the output is spooled to a temporary file so followers that
+	// joined late, or read slowly, still get all of it
+	file, err := os.CreateTemp("", "scyllaridae-flight-*")
+	if err != nil {
+		return nil, false, err
+	}
+	f = &flight{file: file, refs: 1}
+	f.cond = sync.NewCond(&f.mu)
+	g.flights[key] = f
+
+	return f, true, nil
+}
+
+// land marks the flight for key as done, so new requests start a new flight.
+// err is the error the command failed with, if any.
+func (g *flightGroup) land(key string, f *flight, err error) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+
+	f.mu.Lock()
+	f.done = true
+	f.err = err
+	f.mu.Unlock()
+	f.cond.Broadcast()
+}
+
+// flight is the output of a running command, shared by every request waiting for it.
+type flight struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	file     *os.File
+	written  int64
+	done     bool
+	err      error
+	writeErr error
+	refs     int
+}
+
+// Write spools the command's output. Errors are logged rather than returned
+// so the leader's command and response aren't affected.
+func (f *flight) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	failed := f.writeErr != nil
+	f.mu.Unlock()
+	if failed {
+		return len(p), nil
+	}
+
+	n, err := f.file.WriteAt(p, f.written)
+	f.mu.Lock()
+	f.written += int64(n)
+	if err != nil {
+		slog.Error("Unable to spool output for coalesced requests", "err", err)
+		f.writeErr = err
+	}
+	f.mu.Unlock()
+	f.cond.Broadcast()
+
+	return len(p), nil
+}
+
+// reader returns a reader of the flight's output, which blocks until more output is written
+// and returns the command's error, if any, once all the output is read.
+func (f *flight) reader() io.Reader {
+	return &flightReader{f: f}
+}
+
+// release drops a reference to the flight, removing the spooled output once it's unused.
+func (f *flight) release() {
+	f.mu.Lock()
+	f.refs--
+	unused := f.refs == 0
+	f.mu.Unlock()
+	if unused {
+		f.file.Close()
+		os.Remove(f.file.Name())
+	}
+}
+
+type flightReader struct {
+	f      *flight
+	offset int64
+}
+
+func (r *flightReader) Read(p []byte) (int, error) {
+	f := r.f
+	f.mu.Lock()
+	for r.offset == f.written && !f.done {
+		f.cond.Wait()
+	}
+	written, done, err := f.written, f.done, f.err
+	if err == nil && f.writeErr != nil {
+		err = f.writeErr
+	}
+	f.mu.Unlock()
+
+	if r.offset == written && done {
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	n, readErr := f.file.ReadAt(p[:min(int64(len(p)), written-r.offset)], r.offset)
+	r.offset += int64(n)
+	if readErr == io.EOF {
+		readErr = nil
+	}
+
+	return n, readErr
+}
+
+// flightKey identifies requests that would run the same command on the same source.
+// Like cacheKey, it includes the source's version when it's known,
+// so a request for a source that changed doesn't get the old version's output.
+// It returns an empty key for requests that can't share output, like uploads.
+func flightKey(cmd *exec.Cmd, command scyllaridae.Command, message api.Payload, src *scyllaridae.Source) string {
+	if message.Attachment.Content.SourceURI == "" {
+		return ""
+	}
+
+	return hashKey(append([]string{message.Attachment.Content.SourceURI, src.Version, message.Attachment.Content.DestinationMimeType}, commandKey(cmd, command)...)...)
+}
+
+// followFlight responds with the output of a command another request is running.
+func (s *Server) followFlight(w http.ResponseWriter, bw *bufferingWriter, f *flight) {
+	if _, err := io.Copy(bw, f.reader()); err != nil {
+		slog.Error("Coalesced command failed", "err", err)
+		if !bw.flushed {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		slog.Warn("Coalesced command failed after streaming started", "bytesWritten", bw.totalWrites)
+		return
+	}
+
+	if err := bw.finish(); err != nil {
+		slog.Error("Error flushing output", "err", err)
+	}
+}
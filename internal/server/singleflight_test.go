@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageHandler_CoalesceRequests(t *testing.T) {
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	tests := []struct {
+		name          string
+		script        string
+		coalesce      bool
+		wantStatus    int
+		wantBody      string
+		wantCommands  int
+		wantCoalesced int
+	}{
+		{
+			name:          "identical requests share one command",
+			script:        "sleep 0.5; cat; echo derivative",
+			coalesce:      true,
+			wantStatus:    http.StatusOK,
+			wantBody:      "sourcederivative\n",
+			wantCommands:  1,
+			wantCoalesced: 4,
+		},
+		{
+			name:          "failure is shared",
+			script:        "sleep 0.5; exit 1",
+			coalesce:      true,
+			wantStatus:    http.StatusInternalServerError,
+			wantBody:      "Internal error\n",
+			wantCommands:  1,
+			wantCoalesced: 4,
+		},
+		{
+			name:          "disabled",
+			script:        "sleep 0.5; cat; echo derivative",
+			coalesce:      false,
+			wantStatus:    http.StatusOK,
+			wantBody:      "sourcederivative\n",
+			wantCommands:  5,
+			wantCoalesced: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fa := true
+			server := &Server{
+				Config: &scyllaridae.ServerConfig{
+					ForwardAuth:      &fa,
+					AllowedMimeTypes: []string{"*"},
+					SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+					Coalesce:         &tt.coalesce,
+					CmdByMimeType: map[string]scyllaridae.Command{
+						"default": {
+							Cmd:  "sh",
+							Args: []string{"-c", tt.script},
+						},
+					},
+				},
+			}
+			router := server.SetupRouter()
+
+			var wg sync.WaitGroup
+			for range 5 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req := httptest.NewRequest("GET", "/", nil)
+					req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+					rr := httptest.NewRecorder()
+					router.ServeHTTP(rr, req)
+
+					assert.Equal(t, tt.wantStatus, rr.Code)
+					assert.Equal(t, tt.wantBody, rr.Body.String())
+				}()
+			}
+			wg.Wait()
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+			assert.Contains(t, rr.Body.String(), fmt.Sprintf("%s %d\n", metricCommands, tt.wantCommands))
+			assert.Contains(t, rr.Body.String(), fmt.Sprintf("%s %d\n", metricCoalesced, tt.wantCoalesced))
+		})
+	}
+}
+
+// disconnectedWriter is a client that's gone, so writes to it fail.
+type disconnectedWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (d disconnectedWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestMessageHandler_CoalesceLeaderDisconnects(t *testing.T) {
+	mockSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("source"))
+	}))
+	defer mockSource.Close()
+
+	fa, coalesce := true, true
+	server := &Server{
+		Config: &scyllaridae.ServerConfig{
+			ForwardAuth:      &fa,
+			AllowedMimeTypes: []string{"*"},
+			SourcePolicy:     &scyllaridae.SourcePolicy{AllowedCIDRs: []string{"127.0.0.0/8"}},
+			Coalesce:         &coalesce,
+			CmdByMimeType: map[string]scyllaridae.Command{
+				"default": {
+					Cmd: "sh",
+					// more than the response buffer, so the leader's client is written to while the command runs
+					Args: []string{"-c", "sleep 0.5; head -c 3000000 /dev/zero; echo done"},
+				},
+			},
+		},
+	}
+	router := server.SetupRouter()
+	request := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Apix-Ldp-Resource", mockSource.URL)
+		return req
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		router.ServeHTTP(disconnectedWriter{httptest.NewRecorder()}, request())
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request())
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, 3000005, rr.Body.Len())
+			assert.True(t, strings.HasSuffix(rr.Body.String(), "done\n"))
+		}()
+	}
+	wg.Wait()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf("%s %d\n", metricCommands, 1))
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf("%s %d\n", metricCoalesced, 3))
+}
+
+func TestFlight_Reader(t *testing.T) {
+	g := newFlightGroup()
+	f, leader, err := g.join("key")
+	assert.NoError(t, err)
+	assert.True(t, leader)
+
+	follower, leader, err := g.join("key")
+	assert.NoError(t, err)
+	assert.False(t, leader)
+	assert.Same(t, f, follower)
+
+	done := make(chan string)
+	go func() {
+		var b strings.Builder
+		buf := make([]byte, 3)
+		r := follower.reader()
+		for {
+			n, err := r.Read(buf)
+			b.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		done <- b.String()
+	}()
+
+	_, _ = f.Write([]byte("hello "))
+	_, _ = f.Write([]byte("world"))
+	g.land("key", f, nil)
+	assert.Equal(t, "hello world", <-done)
+
+	// a new request after landing starts a new flight
+	next, leader, err := g.join("key")
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	next.release()
+	f.release()
+	follower.release()
+}
+
+func TestFlightKey(t *testing.T) {
+	cmd := exec.Command("convert", "-", "webp:-")
+	message := api.Payload{}
+	message.Attachment.Content.SourceURI = "https://example.com/files/scan.tiff"
+	message.Attachment.Content.DestinationMimeType = "image/webp"
+
+	v1 := flightKey(cmd, scyllaridae.Command{}, message, &scyllaridae.Source{Version: "etag-1"})
+	assert.NotEmpty(t, v1)
+	assert.Equal(t, v1, flightKey(cmd, scyllaridae.Command{}, message, &scyllaridae.Source{Version: "etag-1"}))
+	assert.NotEqual(t, v1, flightKey(cmd, scyllaridae.Command{}, message, &scyllaridae.Source{Version: "etag-2"}))
+	assert.NotEqual(t, v1, flightKey(cmd, scyllaridae.Command{}, message, &scyllaridae.Source{}))
+
+	// uploads can't share output
+	message.Attachment.Content.SourceURI = ""
+	assert.Empty(t, flightKey(cmd, scyllaridae.Command{}, message, &scyllaridae.Source{Version: "etag-1"}))
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+)
+
+const clientSubjectKey contextKey = "scyllaridaeClientSubject"
+
+// certReloader serves the configured certificate, reloading it
+// whenever the certificate or key file changes on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := cr.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return cr.fallback(fmt.Errorf("unable to stat certificate: %w", err))
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return cr.fallback(fmt.Errorf("unable to stat key: %w", err))
+	}
+
+	if cr.cert != nil && certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return cr.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		// the cert and key are often not replaced atomically
+		// so keep serving the previous pair until both are readable
+		return cr.fallback(fmt.Errorf("unable to load key pair: %w", err))
+	}
+	if cr.cert != nil {
+		slog.Info("Reloaded TLS certificate", "certFile", cr.certFile)
+	}
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+
+	return cr.cert, nil
+}
+
+func (cr *certReloader) fallback(err error) (*tls.Certificate, error) {
+	if cr.cert == nil {
+		return nil, err
+	}
+	slog.Error("Unable to reload TLS certificate, serving previous certificate", "err", err)
+	return cr.cert, nil
+}
+
+// BuildTLSConfig creates the tls.Config used to serve HTTPS from the server configuration.
+func BuildTLSConfig(c *scyllaridae.TLSConfig) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("tls requires both certFile and keyFile")
+	}
+
+	cr, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion, err := tlsVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tls.Config{
+		GetCertificate: cr.GetCertificate,
+		MinVersion:     minVersion,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+	}
+
+	tc.ClientAuth, err = clientAuthType(c.ClientAuth, c.ClientCAFile != "")
+	if err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	}
+
+	return 0, fmt.Errorf("unknown tls minVersion: %s", v)
+}
+
+func clientAuthType(a string, haveCA bool) (tls.ClientAuthType, error) {
+	switch a {
+	case "":
+		if haveCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "verify-if-given":
+		if !haveCA {
+			return 0, fmt.Errorf("tls clientAuth %s requires clientCaFile", a)
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		if !haveCA {
+			return 0, fmt.Errorf("tls clientAuth %s requires clientCaFile", a)
+		}
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return 0, fmt.Errorf("unknown tls clientAuth: %s", a)
+}
+
+// ClientCertMiddleware makes the subject of a verified client certificate
+// available in the request context and enforces allowedClientSubjects.
+func (s *Server) ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.TLS == nil || r.TLS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		subject := ""
+		// only trust the subject when the chain was verified against clientCaFile
+		if len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			cert := r.TLS.VerifiedChains[0][0]
+			subject = cert.Subject.String()
+			if !s.clientSubjectAllowed(cert) {
+				slog.Error("Client certificate subject not allowed", "subject", subject)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		} else if len(s.Config.TLS.AllowedClientSubjects) > 0 {
+			slog.Error("Missing verified client certificate")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), clientSubjectKey, subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) clientSubjectAllowed(cert *x509.Certificate) bool {
+	allowed := s.Config.TLS.AllowedClientSubjects
+	if len(allowed) == 0 {
+		return true
+	}
+
+	return slices.Contains(allowed, cert.Subject.String()) || slices.Contains(allowed, cert.Subject.CommonName)
+}
+
+// ClientSubject returns the verified client certificate subject for the request, if any.
+func ClientSubject(r *http.Request) string {
+	subject, _ := r.Context().Value(clientSubjectKey).(string)
+	return subject
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	scyllaridae "github.com/islandora/scyllaridae/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func createTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Islandora"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IsCA:         isCA,
+
+		BasicConstraintsValid: true,
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestCert(t *testing.T, dir string, c *testCert) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, c.certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM, 0600))
+
+	return certFile, keyFile
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := createTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := writeTestCert(t, dir, createTestCert(t, "localhost", ca, false))
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM, 0600))
+
+	tests := []struct {
+		name           string
+		config         scyllaridae.TLSConfig
+		wantError      bool
+		wantMinVersion uint16
+		wantClientAuth tls.ClientAuthType
+	}{
+		{
+			name:           "defaults",
+			config:         scyllaridae.TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			wantMinVersion: tls.VersionTLS12,
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:           "client CA defaults to require",
+			config:         scyllaridae.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, MinVersion: "1.3"},
+			wantMinVersion: tls.VersionTLS13,
+			wantClientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:           "verify if given",
+			config:         scyllaridae.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: "verify-if-given"},
+			wantMinVersion: tls.VersionTLS12,
+			wantClientAuth: tls.VerifyClientCertIfGiven,
+		},
+		{
+			name:      "require without CA",
+			config:    scyllaridae.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "require"},
+			wantError: true,
+		},
+		{
+			name:      "bad min version",
+			config:    scyllaridae.TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"},
+			wantError: true,
+		},
+		{
+			name:      "missing key file",
+			config:    scyllaridae.TLSConfig{CertFile: certFile},
+			wantError: true,
+		},
+		{
+			name:      "unreadable key pair",
+			config:    scyllaridae.TLSConfig{CertFile: caFile, KeyFile: filepath.Join(dir, "missing.key")},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := BuildTLSConfig(&tt.config)
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMinVersion, tc.MinVersion)
+			assert.Equal(t, tt.wantClientAuth, tc.ClientAuth)
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := createTestCert(t, "test-ca", nil, true)
+	first := createTestCert(t, "first", ca, false)
+	certFile, keyFile := writeTestCert(t, dir, first)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := cr.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	// make sure the modification time changes even on coarse grained filesystems
+	second := createTestCert(t, "second", ca, false)
+	writeTestCert(t, dir, second)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+
+	cert, err = cr.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+
+	// a half written key pair keeps serving the previous certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0600))
+	later := future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	cert, err = cr.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := createTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := writeTestCert(t, dir, createTestCert(t, "localhost", ca, false))
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM, 0600))
+
+	allowedClient := createTestCert(t, "alpaca", ca, false)
+	deniedClient := createTestCert(t, "mallory", ca, false)
+	untrustedClient := createTestCert(t, "alpaca", createTestCert(t, "other-ca", nil, true), false)
+
+	fa := false
+	testConfig := &scyllaridae.ServerConfig{
+		ForwardAuth:      &fa,
+		AllowedMimeTypes: []string{"*"},
+		CmdByMimeType: map[string]scyllaridae.Command{
+			"default": {
+				Cmd: "cat",
+			},
+		},
+		TLS: &scyllaridae.TLSConfig{
+			CertFile:              certFile,
+			KeyFile:               keyFile,
+			ClientCAFile:          caFile,
+			ClientAuth:            "verify-if-given",
+			AllowedClientSubjects: []string{"alpaca"},
+		},
+	}
+	server := &Server{Config: testConfig}
+	tc, err := BuildTLSConfig(testConfig.TLS)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(server.SetupRouter())
+	ts.TLS = tc
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name       string
+		client     *testCert
+		wantStatus int
+	}{
+		{
+			name:       "allowed client subject",
+			client:     allowedClient,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "denied client subject",
+			client:     deniedClient,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no client certificate",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// the client won't offer a certificate the server's CA didn't sign
+			name:       "untrusted client certificate",
+			client:     untrustedClient,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.client != nil {
+				pair, err := tls.X509KeyPair(tt.client.certPEM, tt.client.keyPEM)
+				require.NoError(t, err)
+				clientTLS.Certificates = []tls.Certificate{pair}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			req, err := http.NewRequest("POST", ts.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "text/plain")
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -5,11 +5,26 @@ import (
 	"os"
 	"strings"
 
+	"github.com/islandora/scyllaridae/internal/cache"
 	"github.com/islandora/scyllaridae/internal/config"
+	"github.com/islandora/scyllaridae/internal/redact"
+	"github.com/islandora/scyllaridae/internal/sandbox"
 	"github.com/islandora/scyllaridae/internal/server"
 )
 
 func main() {
+	// sandboxed commands are started through this binary
+	if len(os.Args) > 1 && os.Args[1] == sandbox.HelperArg {
+		sandbox.Exec(os.Args[2:])
+	}
+	// print the config file JSON Schema, for editors and CI to check configs with
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		if _, err := os.Stdout.Write(config.Schema); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	setupLogger()
 
 	config, err := config.ReadConfig()
@@ -18,7 +33,17 @@ func main() {
 		os.Exit(1)
 	}
 	s := &server.Server{
-		Config: config,
+		Config:   config,
+		Redactor: redact.New(config.Redact),
+	}
+	// secrets are redacted from everything logged from here on
+	slog.SetDefault(slog.New(s.Redactor.Handler(slog.Default().Handler())))
+	if config.Cache != nil {
+		s.Cache, err = cache.New(config.Cache.Dir, config.Cache.MaxBytes)
+		if err != nil {
+			slog.Error("Could not open cache", "dir", config.Cache.Dir, "err", err)
+			os.Exit(1)
+		}
 	}
 	server.RunHTTPServer(s)
 }
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"cmp"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MediaRange is a media range from an Accept header, like image/* or text/plain;q=0.5.
+type MediaRange struct {
+	// MimeType is the range without its quality, e.g. image/* or text/plain; charset=utf-8
+	MimeType string
+	// Q is the range's quality, from 0 for not acceptable to 1 for most preferred
+	Q float64
+}
+
+// Specificity ranks how specific the range is:
+// 0 for */*, 1 for a type wildcard like image/*, 2 for a MIME type
+// and more for a MIME type with parameters.
+func (m MediaRange) Specificity() int {
+	base, params, _ := strings.Cut(m.MimeType, ";")
+	switch {
+	case base == "*/*":
+		return 0
+	case strings.HasSuffix(base, "/*"):
+		return 1
+	case params == "":
+		return 2
+	}
+
+	return 2 + strings.Count(params, "=")
+}
+
+// ParseAccept parses an Accept header into its media ranges, most preferred first:
+// by quality, then by how specific they are, then in the order they're listed.
+// Ranges that can't be parsed are left out.
+func ParseAccept(accept string) []MediaRange {
+	var ranges []MediaRange
+	for part := range strings.SplitSeq(accept, ",") {
+		// the parameters after q are accept extensions rather than part of the range
+		mimeType, q := part, 1.0
+		if i := qIndex(part); i >= 0 {
+			mimeType = part[:i]
+			v, _, _ := strings.Cut(part[i+1:], ";")
+			_, v, _ = strings.Cut(v, "=")
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		base, params, err := mime.ParseMediaType(mimeType)
+		if err != nil || !strings.Contains(base, "/") {
+			continue
+		}
+		ranges = append(ranges, MediaRange{MimeType: mime.FormatMediaType(base, params), Q: q})
+	}
+
+	slices.SortStableFunc(ranges, func(a, b MediaRange) int {
+		if a.Q != b.Q {
+			return cmp.Compare(b.Q, a.Q)
+		}
+		return b.Specificity() - a.Specificity()
+	})
+
+	return ranges
+}
+
+// qIndex returns the index of the ; before a media range's q parameter, or -1 if it doesn't have one.
+func qIndex(part string) int {
+	for i := 0; i < len(part); i++ {
+		if part[i] != ';' {
+			continue
+		}
+		name, _, _ := strings.Cut(part[i+1:], "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// PreferredMimeType returns the most preferred acceptable MIME type in an Accept header,
+// or "" if it only has wildcards.
+func PreferredMimeType(accept string) string {
+	for _, r := range ParseAccept(accept) {
+		if r.Q > 0 && r.Specificity() >= 2 {
+			return r.MimeType
+		}
+	}
+
+	return ""
+}
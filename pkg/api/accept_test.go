@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   []MediaRange
+	}{
+		{
+			name:   "empty",
+			accept: "",
+			want:   nil,
+		},
+		{
+			name:   "single MIME type",
+			accept: "image/jpeg",
+			want:   []MediaRange{{MimeType: "image/jpeg", Q: 1}},
+		},
+		{
+			name:   "browser style list",
+			accept: "image/jpeg, image/png;q=0.8, */*;q=0.1",
+			want: []MediaRange{
+				{MimeType: "image/jpeg", Q: 1},
+				{MimeType: "image/png", Q: 0.8},
+				{MimeType: "*/*", Q: 0.1},
+			},
+		},
+		{
+			name:   "sorted by quality then specificity",
+			accept: "*/*;q=0.5, image/*, text/plain;q=0.5, image/webp",
+			want: []MediaRange{
+				{MimeType: "image/webp", Q: 1},
+				{MimeType: "image/*", Q: 1},
+				{MimeType: "text/plain", Q: 0.5},
+				{MimeType: "*/*", Q: 0.5},
+			},
+		},
+		{
+			name:   "parameters are kept, accept extensions aren't",
+			accept: "text/plain; charset=UTF-8; q=0.9; level=1, text/html",
+			want: []MediaRange{
+				{MimeType: "text/html", Q: 1},
+				{MimeType: "text/plain; charset=UTF-8", Q: 0.9},
+			},
+		},
+		{
+			name:   "invalid ranges are left out",
+			accept: "image, image/png;q=2, image/gif;q=high, ,application/pdf;Q=0",
+			want:   []MediaRange{{MimeType: "application/pdf", Q: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseAccept(tt.accept))
+		})
+	}
+}
+
+func TestPreferredMimeType(t *testing.T) {
+	assert.Equal(t, "image/jpeg", PreferredMimeType("image/jpeg, image/png;q=0.8, */*;q=0.1"))
+	assert.Equal(t, "image/png", PreferredMimeType("image/*, image/png;q=0.8"))
+	assert.Equal(t, "image/png", PreferredMimeType("image/jpeg;q=0, image/png;q=0.1"))
+	assert.Equal(t, "", PreferredMimeType("*/*"))
+	assert.Equal(t, "", PreferredMimeType(""))
+}
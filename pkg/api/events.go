@@ -19,6 +19,8 @@ type Payload struct {
 	Type          string     `json:"type" description:"Type of the payload"`
 	Summary       string     `json:"summary" description:"Summary of the payload"`
 	Authorization string     `json:"authorization" description:"The Authorization HTTP header"`
+
+	Claims map[string]any `json:"-" description:"Claims of the request's JWT"`
 }
 
 // Actor represents an entity performing an action.
@@ -63,11 +65,17 @@ type Attachment struct {
 type Content struct {
 	SourceMimeType      string `json:"source_mimetype,omitempty" description:"MIME type of the source URI"`
 	DestinationMimeType string `json:"mimetype" description:"MIME type of the derivative being created"`
+	Accept              string `json:"-" description:"Accept header the derivative's MIME type is negotiated from"`
 	Args                string `json:"args" description:"Arguments used or applicable to the content"`
+	Preset              string `json:"-" description:"Argument preset selected with the X-Islandora-Preset header"`
 	SourceURI           string `json:"source_uri" description:"Source URI from which the content is fetched"`
 	SourceField         string `json:"source_field" description:"Source field from which the media is fetched"`
 	DestinationURI      string `json:"destination_uri" description:"Destination URI to where the content is delivered"`
 	FileUploadURI       string `json:"file_upload_uri" description:"File upload URI for uploading the content"`
+
+	SourceSize             int64  `json:"-" description:"Size of the source in bytes, or -1 if unknown"`
+	DeclaredSourceMimeType string `json:"-" description:"MIME type the source was sent with"`
+	DetectedSourceMimeType string `json:"-" description:"MIME type detected from the source contents"`
 }
 
 // DecodeEventMessage decodes an event message sent by Islandora directly from ActiveMQ.
@@ -85,12 +93,40 @@ func DecodeEventMessage(msg []byte) (Payload, error) {
 // DecodeAlpacaMessage decodes an event message transformed by Alpaca from HTTP headers.
 // It reads the X-Islandora-Event header (base64-encoded JSON) or constructs a Payload from
 // individual HTTP headers (Apix-Ldp-Resource, Accept, Content-Type, X-Islandora-Args).
+// The destination MIME type is the most preferred MIME type in the Accept header,
+// which can be negotiated further against the MIME types a command can output.
+// For GET requests the source URI's MIME type is looked up with a HEAD request.
 func DecodeAlpacaMessage(r *http.Request, auth string) (Payload, error) {
+	return DecodeAlpacaMessageWithClient(r, auth, http.DefaultClient)
+}
+
+// DecodeAlpacaMessageWithClient decodes an event message like DecodeAlpacaMessage,
+// using client to look up the MIME type of the source URI.
+func DecodeAlpacaMessageWithClient(r *http.Request, auth string, client *http.Client) (Payload, error) {
+	p, err := ParseAlpacaMessage(r, auth)
+	if err != nil || r.Method == http.MethodPost {
+		return p, err
+	}
+
+	err = p.FetchSourceMimeType(client, auth)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// ParseAlpacaMessage decodes an event message like DecodeAlpacaMessage
+// without making any requests to the source URI.
+// Callers that fetch the source themselves can take its MIME type from that response instead.
+func ParseAlpacaMessage(r *http.Request, auth string) (Payload, error) {
 	p := Payload{}
 
 	p.Attachment.Content.Args = r.Header.Get("X-Islandora-Args")
+	p.Attachment.Content.Preset = r.Header.Get("X-Islandora-Preset")
 	p.Attachment.Content.SourceURI = r.Header.Get("Apix-Ldp-Resource")
-	p.Attachment.Content.DestinationMimeType = r.Header.Get("Accept")
+	p.Attachment.Content.Accept = r.Header.Get("Accept")
+	p.Attachment.Content.DestinationMimeType = PreferredMimeType(p.Attachment.Content.Accept)
 	p.Attachment.Content.SourceMimeType = r.Header.Get("Content-Type")
 	if p.Attachment.Content.DestinationMimeType == "" {
 		p.Attachment.Content.DestinationMimeType = "text/plain"
@@ -108,30 +144,32 @@ func DecodeAlpacaMessage(r *http.Request, auth string) (Payload, error) {
 			slog.Error("Error decoding base64", "err", err)
 			return p, err
 		}
+		accepted := p.Attachment.Content.DestinationMimeType
+		p.Attachment.Content.DestinationMimeType = ""
 		err = json.Unmarshal(j, &p)
 		if err != nil {
 			slog.Error("Error unmarshalling event", "err", err)
 			return p, err
 		}
-	}
-
-	slog.Debug("Got message", "msgId", p.Object.ID, "payload.attachment", p.Attachment)
-	err := p.getSourceUri(auth)
-	if err != nil {
-		return p, err
+		if p.Attachment.Content.DestinationMimeType == "" {
+			p.Attachment.Content.DestinationMimeType = accepted
+		} else if p.Attachment.Content.Accept == "" {
+			// without an Accept header the event's MIME type is the one wanted
+			p.Attachment.Content.Accept = p.Attachment.Content.DestinationMimeType
+		}
 	}
 
 	return p, nil
 }
 
-func (p *Payload) getSourceUri(auth string) error {
+// FetchSourceMimeType sets the source MIME type from the Content-Type
+// returned by a HEAD request to the source URI.
+func (p *Payload) FetchSourceMimeType(client *http.Client, auth string) error {
 	if p.Attachment.Content.SourceURI == "" {
 		return nil
 	}
 	slog.Debug("Fetching Content-Type HTTP header for SourceURI mime type", "msgId", p.Object.ID, "SourceURI", p.Attachment.Content.SourceURI)
 
-	client := &http.Client{}
-
 	req, err := http.NewRequest("HEAD", p.Attachment.Content.SourceURI, nil)
 	if err != nil {
 		slog.Error("Unable to create source URI request", "uri", p.Attachment.Content.SourceURI, "err", err)
@@ -145,10 +183,15 @@ func (p *Payload) getSourceUri(auth string) error {
 	resp, err := client.Do(req)
 	if err != nil {
 		slog.Error("Unable to get source URI", "uri", p.Attachment.Content.SourceURI, "err", err)
-		return fmt.Errorf("error issuing HEAD request on %s", p.Attachment.Content.SourceURI)
+		return fmt.Errorf("error issuing HEAD request on %s: %w", p.Attachment.Content.SourceURI, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Error("SourceURI sent a bad status code", "code", resp.StatusCode, "uri", p.Attachment.Content.SourceURI)
+		return fmt.Errorf("HEAD request on %s returned %d", p.Attachment.Content.SourceURI, resp.StatusCode)
+	}
+
 	p.Attachment.Content.SourceMimeType = resp.Header.Get("Content-Type")
 
 	slog.Debug("Got SourceURI mime type", "msgId", p.Object.ID, "SourceMimeType", p.Attachment.Content.SourceMimeType)
@@ -79,6 +79,27 @@ func TestDecodeAlpacaMessage(t *testing.T) {
 			wantSrcMimeType:  "text/plain",
 			wantError:        false,
 		},
+		{
+			name:   "browser style Accept header",
+			method: "POST",
+			headers: map[string]string{
+				"Content-Type": "image/tiff",
+				"Accept":       "image/jpeg, image/png;q=0.8, */*;q=0.1",
+			},
+			wantDestMimeType: "image/jpeg",
+			wantSrcMimeType:  "image/tiff",
+			wantError:        false,
+		},
+		{
+			name:   "wildcard Accept header",
+			method: "POST",
+			headers: map[string]string{
+				"Content-Type": "image/tiff",
+				"Accept":       "*/*",
+			},
+			wantDestMimeType: "text/plain",
+			wantError:        false,
+		},
 		{
 			name:   "default Accept header",
 			method: "POST",
@@ -159,7 +180,7 @@ func TestDecodeAlpacaMessage_InvalidJSONInEvent(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestGetSourceUri_ErrorCases(t *testing.T) {
+func TestFetchSourceMimeType_ErrorCases(t *testing.T) {
 	tests := []struct {
 		name      string
 		sourceURI string
@@ -187,7 +208,7 @@ func TestGetSourceUri_ErrorCases(t *testing.T) {
 				},
 			}
 
-			err := p.getSourceUri("")
+			err := p.FetchSourceMimeType(http.DefaultClient, "")
 			if tt.wantError {
 				assert.Error(t, err)
 			} else {
@@ -197,7 +218,7 @@ func TestGetSourceUri_ErrorCases(t *testing.T) {
 	}
 }
 
-func TestGetSourceUri_UnreachableServer(t *testing.T) {
+func TestFetchSourceMimeType_UnreachableServer(t *testing.T) {
 	p := &Payload{
 		Object: Object{ID: "test-123"},
 		Attachment: Attachment{
@@ -207,7 +228,7 @@ func TestGetSourceUri_UnreachableServer(t *testing.T) {
 		},
 	}
 
-	err := p.getSourceUri("")
+	err := p.FetchSourceMimeType(http.DefaultClient, "")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "error issuing HEAD request")
 }
@@ -225,8 +246,80 @@ func TestDecodeAlpacaMessage_FailedSourceURIFetch(t *testing.T) {
 	req.Header.Set("X-Islandora-Event", invalidJSON)
 
 	payload, err := DecodeAlpacaMessage(req, "")
-	assert.NoError(t, err) // DecodeAlpacaMessage itself doesn't error, just populates the payload
+	assert.Error(t, err) // the HEAD request's status is checked
+	assert.Equal(t, mockServer.URL, payload.Attachment.Content.SourceURI)
+}
+
+func TestParseAlpacaMessage_NoSourceRequest(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request to the source, got %s", r.Method)
+	}))
+	defer mockServer.Close()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	event := base64.StdEncoding.EncodeToString([]byte(`{"attachment":{"content":{"source_uri":"` + mockServer.URL + `","source_mimetype":"image/tiff"}}}`))
+	req.Header.Set("X-Islandora-Event", event)
+
+	payload, err := ParseAlpacaMessage(req, "")
+	assert.NoError(t, err)
 	assert.Equal(t, mockServer.URL, payload.Attachment.Content.SourceURI)
+	assert.Equal(t, "image/tiff", payload.Attachment.Content.SourceMimeType)
+}
+
+func TestParseAlpacaMessage_Accept(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		event      string
+		wantAccept string
+		wantDest   string
+	}{
+		{
+			name:       "Accept header",
+			accept:     "image/png;q=0.8, image/webp",
+			wantAccept: "image/png;q=0.8, image/webp",
+			wantDest:   "image/webp",
+		},
+		{
+			name:       "event MIME type without an Accept header",
+			event:      `{"attachment":{"content":{"mimetype":"image/webp"}}}`,
+			wantAccept: "image/webp",
+			wantDest:   "image/webp",
+		},
+		{
+			name:       "event MIME type with an Accept header",
+			accept:     "image/*",
+			event:      `{"attachment":{"content":{"mimetype":"image/webp"}}}`,
+			wantAccept: "image/*",
+			wantDest:   "image/webp",
+		},
+		{
+			name:       "event without a MIME type",
+			accept:     "application/pdf",
+			event:      `{"attachment":{"content":{"source_uri":"https://example.com/a.tiff"}}}`,
+			wantAccept: "application/pdf",
+			wantDest:   "application/pdf",
+		},
+		{
+			name:       "neither",
+			event:      `{"attachment":{"content":{"source_uri":"https://example.com/a.tiff"}}}`,
+			wantAccept: "",
+			wantDest:   "text/plain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			req.Header.Set("X-Islandora-Event", base64.StdEncoding.EncodeToString([]byte(tt.event)))
+
+			payload, err := ParseAlpacaMessage(req, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAccept, payload.Attachment.Content.Accept)
+			assert.Equal(t, tt.wantDest, payload.Attachment.Content.DestinationMimeType)
+		})
+	}
 }
 
 func TestPayloadStructures(t *testing.T) {